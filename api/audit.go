@@ -1,10 +1,15 @@
 package api
 
 type AuditResponseDto struct {
-	ID          int    `json:"audit_id"`
-	Accion      string `json:"action"`
-	Entidad     string `json:"entity"`
-	EntidadID   int    `json:"entity_id"`
-	Descripcion string `json:"description"`
-	Fecha       string `json:"created_at"`
+	ID             int    `json:"audit_id"`
+	Accion         string `json:"action"`
+	Entidad        string `json:"entity"`
+	EntidadID      int    `json:"entity_id"`
+	Descripcion    string `json:"description"`
+	Fecha          string `json:"created_at"`
+	ActorUsuarioID *int   `json:"actor_user_id,omitempty"`
+	ActorEmail     string `json:"actor_email"`
+	ActorRol       string `json:"actor_role"`
+	IPOrigen       string `json:"source_ip,omitempty"`
+	SolicitudID    string `json:"request_id,omitempty"`
 }
@@ -0,0 +1,11 @@
+package api
+
+type HealthResponseDto struct {
+	Estado      string                        `json:"status"`
+	Componentes map[string]ComponentHealthDto `json:"components,omitempty"`
+}
+
+type ComponentHealthDto struct {
+	Estado  string `json:"status"`
+	Detalle string `json:"detail,omitempty"`
+}
@@ -8,6 +8,10 @@ type MissionRequestDto struct {
 	LegacyAsignadoAID *int    `json:"assigned_to_id"`
 }
 
+type MissionStatusRequestDto struct {
+	Estado string `json:"status"`
+}
+
 type MissionResponseDto struct {
 	ID                int    `json:"id"`
 	Titulo            string `json:"title"`
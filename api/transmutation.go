@@ -16,14 +16,19 @@ type TransmutationRequestDto struct {
 }
 
 type TransmutationResponseDto struct {
-	ID                     int                   `json:"id"`
-	AlchemistID            int                   `json:"alchemist_id"`
-	Description            string                `json:"description"`
-	Status                 string                `json:"status"`
-	CreatedAt              string                `json:"created_at"`
-	EstimatedCost          float64               `json:"estimated_cost,omitempty"`
-	EstimatedDurationTotal int                   `json:"estimated_duration_seconds,omitempty"`
-	Alchemist              *AlchemistResponseDto `json:"alchemist,omitempty"`
+	ID                     int                                           `json:"id"`
+	AlchemistID            int                                           `json:"alchemist_id"`
+	Description            string                                        `json:"description"`
+	Status                 string                                        `json:"status"`
+	CreatedAt              string                                        `json:"created_at"`
+	EstimatedCost          float64                                       `json:"estimated_cost,omitempty"`
+	EstimatedDurationTotal int                                           `json:"estimated_duration_seconds,omitempty"`
+	Complexity             string                                        `json:"complexity,omitempty"`
+	RiskLevel              string                                        `json:"risk_level,omitempty"`
+	CatalystQuality        int                                           `json:"catalyst_quality,omitempty"`
+	FailureReason          string                                        `json:"failure_reason,omitempty"`
+	Materials              []TransmutationSimulationMaterialBreakdownDto `json:"materials,omitempty"`
+	Alchemist              *AlchemistResponseDto                         `json:"alchemist,omitempty"`
 }
 
 type TransmutationTaskResponseDto struct {
@@ -0,0 +1,45 @@
+package api
+
+type UserRequestDto struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	Rol          string `json:"role"`
+	AlquimistaID *uint  `json:"alchemist_id"`
+}
+
+type UserResponseDto struct {
+	ID             int    `json:"id"`
+	Email          string `json:"email"`
+	Rol            string `json:"role"`
+	AlquimistaID   *int   `json:"alchemist_id,omitempty"`
+	Deshabilitado  bool   `json:"disabled"`
+	BloqueadoHasta string `json:"locked_until,omitempty"`
+	DobleFactor    bool   `json:"two_factor_enabled"`
+	Fecha          string `json:"created_at"`
+}
+
+// UserPatchRequestDto: los campos ausentes no cambian; alchemist_id 0 desvincula.
+type UserPatchRequestDto struct {
+	Rol           *string `json:"role"`
+	Deshabilitado *bool   `json:"disabled"`
+	AlquimistaID  *uint   `json:"alchemist_id"`
+}
+
+type InvitationRequestDto struct {
+	Email        string `json:"email"`
+	Rol          string `json:"role"`
+	AlquimistaID *uint  `json:"alchemist_id"`
+	// horas de validez; 0 usa el valor por defecto
+	ExpiraEnHoras int `json:"expires_in_hours"`
+}
+
+type InvitationResponseDto struct {
+	ID           int    `json:"id"`
+	Email        string `json:"email,omitempty"`
+	Rol          string `json:"role"`
+	AlquimistaID *int   `json:"alchemist_id,omitempty"`
+	ExpiraEn     string `json:"expires_at"`
+	Usada        bool   `json:"used"`
+	// Token solo se devuelve al crear la invitación
+	Token string `json:"token,omitempty"`
+}
@@ -0,0 +1,33 @@
+package api
+
+type WebhookRequestDto struct {
+	URL     string   `json:"url"`
+	Eventos []string `json:"events"`
+	// Secreto opcional; si falta al crear se genera uno
+	Secreto string `json:"secret"`
+	Activo  *bool  `json:"active"`
+}
+
+type WebhookResponseDto struct {
+	ID      int      `json:"id"`
+	URL     string   `json:"url"`
+	Eventos []string `json:"events"`
+	Activo  bool     `json:"active"`
+	Secreto string   `json:"secret,omitempty"`
+	Fecha   string   `json:"created_at"`
+}
+
+type WebhookDeliveryResponseDto struct {
+	ID         int    `json:"id"`
+	WebhookID  int    `json:"webhook_id"`
+	EntregaID  string `json:"delivery_id"`
+	Evento     string `json:"event"`
+	Intento    int    `json:"attempt"`
+	CodigoHTTP int    `json:"status_code"`
+	Exitosa    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	DuracionMs int64  `json:"duration_ms"`
+	// ProximoIntento solo viene mientras quede un reintento pendiente
+	ProximoIntento string `json:"next_attempt_at,omitempty"`
+	Fecha          string `json:"created_at"`
+}
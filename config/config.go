@@ -2,12 +2,95 @@ package config
 
 type Config struct {
 	Address  string `json:"address"`
-	Database string `json:"database"`
+	Database string `json:"database"` // "sqlite" o "postgres"
+	// DSN de postgres; si falta se toma DATABASE_URL o las variables POSTGRES_*
+	DatabaseURL string `json:"database_url"`
+	SQLitePath  string `json:"sqlite_path"`
 	// Para transmutaciones (segundos)
 	TransmutationDuration     int `json:"transmutation_duration"`
 	TransmutationDurationHigh int `json:"transmutation_duration_high"`
+	// Semilla del resultado éxito/fallo; 0 usa una semilla aleatoria por arranque
+	TransmutationOutcomeSeed int64 `json:"transmutation_outcome_seed"`
+	// Transmutaciones simultáneas (pendientes o en curso) por alquimista según su rango
+	TransmutationConcurrencyDefault int            `json:"transmutation_concurrency_default"`
+	TransmutationConcurrencyByRank  map[string]int `json:"transmutation_concurrency_by_rank"`
+
+	// Orígenes aceptados por /ws; vacío = solo el mismo host, "*" = cualquiera
+	WSAllowedOrigins []string `json:"ws_allowed_origins"`
+
+	// IPs o CIDR de los proxies cuyos X-Forwarded-For / X-Real-IP se creen;
+	// vacío = se usa siempre la IP de la conexión
+	TrustedProxies []string `json:"trusted_proxies"`
 
 	DailyCheckHour            string  `json:"daily_check_hour"`
 	MaterialLowStockThreshold float64 `json:"material_low_stock_threshold"`
 	MissionStaleDays          int     `json:"mission_stale_days"`
+
+	// Asignación de misiones
+	MaxOpenMissionsPerAlchemist int  `json:"max_open_missions_per_alchemist"`
+	MissionRejectBusyAlchemist  bool `json:"mission_reject_busy_alchemist"`
+
+	// Contraseñas y recuperación de cuenta
+	PasswordPolicy          PasswordPolicy `json:"password_policy"`
+	PasswordResetTTLMinutes int            `json:"password_reset_ttl_minutes"`
+	// URL del frontend para restablecer; el token se agrega como ?token=
+	PasswordResetURL string         `json:"password_reset_url"`
+	Notifier         NotifierConfig `json:"notifier"`
+
+	// Protección contra fuerza bruta en /auth/login
+	LoginProtection LoginProtection `json:"login_protection"`
+
+	// Segundo factor TOTP
+	TwoFactor TwoFactorConfig `json:"two_factor"`
+
+	Log LogConfig `json:"log"`
+
+	Metrics MetricsConfig `json:"metrics"`
+}
+
+type MetricsConfig struct {
+	// GET /metrics en la dirección pública exige Authorization: Bearer <token>;
+	// vacío, la ruta no se publica ahí
+	Token string `json:"token"`
+	// dirección interna (p. ej. "127.0.0.1:9090") donde /metrics se sirve sin
+	// token; vacía, no se abre ese listener
+	Address string `json:"address"`
+}
+
+type LogConfig struct {
+	Format string `json:"format"` // "text" (por defecto) o "json"
+	Level  string `json:"level"`  // "debug", "info" (por defecto), "warn" o "error"
+}
+
+type TwoFactorConfig struct {
+	// nombre que muestra la app autenticadora
+	Issuer string `json:"issuer"`
+	// obliga a los SUPERVISOR a activar 2FA antes de usar la API
+	RequiredForSupervisors bool `json:"required_for_supervisors"`
+	ChallengeTTLMinutes    int  `json:"challenge_ttl_minutes"`
+}
+
+type LoginProtection struct {
+	// fallos por email dentro de la ventana antes de bloquear la cuenta
+	MaxFailures    int `json:"max_failures"`
+	WindowMinutes  int `json:"window_minutes"`
+	LockoutMinutes int `json:"lockout_minutes"`
+	// fallos por IP (de cualquier email) antes de rechazar esa IP
+	IPMaxFailures int `json:"ip_max_failures"`
+	// demora del n-ésimo fallo: base * 2^(n-1), hasta max
+	DelayBaseMs int `json:"delay_base_ms"`
+	DelayMaxMs  int `json:"delay_max_ms"`
+}
+
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+}
+
+type NotifierConfig struct {
+	Type string `json:"type"` // "log" (por defecto) o "file"
+	Path string `json:"path"`
 }
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change es un campo que difiere entre dos configuraciones.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// secretFields no muestran su valor en el diff.
+var secretFields = map[string]bool{
+	"database_url":  true,
+	"metrics.token": true,
+}
+
+// Diff compara campo a campo usando los nombres de config.json
+// (p. ej. "login_protection.max_failures"), ordenados por nombre.
+func Diff(old, next *Config) []Change {
+	before, after := flatten(old), flatten(next)
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	changes := []Change{}
+	for field := range fields {
+		o, n := before[field], after[field]
+		if o == n {
+			continue
+		}
+		if secretFields[field] {
+			o, n = "***", "***"
+		}
+		changes = append(changes, Change{Field: field, Old: o, New: n})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// KeepStartupFields copia en next los campos que solo se leen al arrancar
+// (servidor HTTP, base de datos, notifier, logs, semilla) y devuelve los que
+// cambiaron: esos requieren reiniciar.
+func KeepStartupFields(current, next *Config) []string {
+	var pinned []string
+	pin := func(field string, dst, src interface{}) {
+		d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+		if !reflect.DeepEqual(d.Interface(), s.Interface()) {
+			pinned = append(pinned, field)
+			d.Set(s)
+		}
+	}
+	pin("address", &next.Address, &current.Address)
+	pin("database", &next.Database, &current.Database)
+	pin("database_url", &next.DatabaseURL, &current.DatabaseURL)
+	pin("sqlite_path", &next.SQLitePath, &current.SQLitePath)
+	pin("notifier", &next.Notifier, &current.Notifier)
+	pin("log", &next.Log, &current.Log)
+	pin("metrics.address", &next.Metrics.Address, &current.Metrics.Address)
+	pin("transmutation_outcome_seed", &next.TransmutationOutcomeSeed, &current.TransmutationOutcomeSeed)
+	return pinned
+}
+
+// flatten pasa la configuración a "campo.subcampo" => valor en JSON.
+func flatten(c *Config) map[string]string {
+	out := map[string]string{}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return out
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return out
+	}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok {
+			for k, child := range m {
+				key := k
+				if prefix != "" {
+					key = prefix + "." + k
+				}
+				walk(key, child)
+			}
+			return
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			out[prefix] = fmt.Sprint(v)
+			return
+		}
+		out[prefix] = string(b)
+	}
+	walk("", tree)
+	return out
+}
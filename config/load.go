@@ -0,0 +1,318 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPath se usa si no se pasa -config; si no existe se arranca con
+	// los valores por defecto y el entorno.
+	DefaultPath = "config/config.json"
+	// EnvPrefix antecede al nombre de cada campo en el entorno, p. ej.
+	// AMETRIS_ADDRESS o AMETRIS_LOGIN_PROTECTION_MAX_FAILURES.
+	EnvPrefix = "AMETRIS_"
+
+	// bcrypt ignora lo que pase de 72 bytes
+	maxPasswordMinLength = 72
+)
+
+// Default devuelve la configuración base que pisan config.json y el entorno.
+func Default() Config {
+	return Config{
+		Address:                         ":8000",
+		Database:                        "sqlite",
+		SQLitePath:                      "test.db",
+		TransmutationDuration:           6,
+		TransmutationDurationHigh:       12,
+		TransmutationConcurrencyDefault: 1,
+		DailyCheckHour:                  "02:00",
+		MaterialLowStockThreshold:       10,
+		MissionStaleDays:                7,
+		MaxOpenMissionsPerAlchemist:     5,
+		PasswordPolicy:                  PasswordPolicy{MinLength: 8},
+		PasswordResetTTLMinutes:         30,
+		Notifier:                        NotifierConfig{Type: "log"},
+		Log:                             LogConfig{Format: "text", Level: "info"},
+	}
+}
+
+// ValidationError junta todos los problemas de la configuración para
+// informarlos de una vez al arrancar.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load arma la configuración por capas: valores por defecto, el archivo JSON
+// y las variables de entorno; al final la valida. path vacío usa DefaultPath.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, &ValidationError{Problems: []string{fmt.Sprintf("%s: %v", path, err)}}
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+	default:
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	problems := applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix, os.LookupEnv)
+	cfg.applyDatabaseEnv()
+	problems = append(problems, cfg.problems()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return &cfg, nil
+}
+
+// applyDatabaseEnv mantiene las variables que ya usa docker-compose:
+// DATABASE_URL y, si no está, POSTGRES_HOST/USER/PASSWORD/DB/PORT.
+func (c *Config) applyDatabaseEnv() {
+	if c.DatabaseURL != "" {
+		return
+	}
+	if dsn := strings.TrimSpace(os.Getenv("DATABASE_URL")); dsn != "" {
+		c.DatabaseURL = dsn
+		return
+	}
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		return
+	}
+	port := os.Getenv("POSTGRES_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	c.DatabaseURL = fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
+		host, os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_DB"), port,
+	)
+}
+
+// applyEnv recorre los campos por su tag json y aplica PREFIJO_CAMPO del
+// entorno. Listas separadas por comas; mapas como CLAVE=valor,CLAVE=valor.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) []string {
+	var problems []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(tag)
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			problems = append(problems, applyEnv(fv, name+"_", lookup)...)
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(fv, strings.TrimSpace(raw)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return problems
+}
+
+func setFromEnv(fv reflect.Value, raw string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		fv.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		fv.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		fv.SetBool(b)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		m := map[string]int{}
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if !ok || err != nil {
+				return fmt.Errorf("invalid entry %q, expected KEY=number", pair)
+			}
+			m[strings.TrimSpace(key)] = n
+		}
+		fv.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+func validProxy(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return true
+	}
+	return net.ParseIP(entry) != nil
+}
+
+// problems lista cada campo inválido con su nombre en config.json.
+func (c *Config) problems() []string {
+	var p []string
+	add := func(format string, args ...interface{}) { p = append(p, fmt.Sprintf(format, args...)) }
+
+	if strings.TrimSpace(c.Address) == "" {
+		add("address is required")
+	}
+	if c.Metrics.Address != "" && c.Metrics.Address == c.Address {
+		add("metrics.address must differ from address")
+	}
+	switch c.Database {
+	case "sqlite":
+		if strings.TrimSpace(c.SQLitePath) == "" {
+			add("sqlite_path is required when database is sqlite")
+		}
+	case "postgres":
+		if strings.TrimSpace(c.DatabaseURL) == "" {
+			add("database_url (or DATABASE_URL / POSTGRES_HOST) is required when database is postgres")
+		}
+	default:
+		add("database must be sqlite or postgres, got %q", c.Database)
+	}
+
+	if c.TransmutationDuration <= 0 {
+		add("transmutation_duration must be greater than 0, got %d", c.TransmutationDuration)
+	}
+	if c.TransmutationDurationHigh < c.TransmutationDuration {
+		add("transmutation_duration_high (%d) must be >= transmutation_duration (%d)", c.TransmutationDurationHigh, c.TransmutationDuration)
+	}
+	if c.TransmutationConcurrencyDefault < 0 {
+		add("transmutation_concurrency_default must not be negative")
+	}
+	ranks := make([]string, 0, len(c.TransmutationConcurrencyByRank))
+	for rank := range c.TransmutationConcurrencyByRank {
+		ranks = append(ranks, rank)
+	}
+	sort.Strings(ranks)
+	for _, rank := range ranks {
+		if c.TransmutationConcurrencyByRank[rank] < 0 {
+			add("transmutation_concurrency_by_rank.%s must not be negative", rank)
+		}
+	}
+	for _, origin := range c.WSAllowedOrigins {
+		if strings.TrimSpace(origin) == "" {
+			add("ws_allowed_origins must not contain empty entries")
+			break
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if !validProxy(proxy) {
+			add("trusted_proxies: %q is not an IP or CIDR", proxy)
+		}
+	}
+
+	if c.DailyCheckHour != "" {
+		if _, err := time.Parse("15:04", c.DailyCheckHour); err != nil {
+			add("daily_check_hour must be HH:MM, got %q", c.DailyCheckHour)
+		}
+	}
+	if c.MaterialLowStockThreshold < 0 {
+		add("material_low_stock_threshold must not be negative")
+	}
+	if c.MissionStaleDays < 0 {
+		add("mission_stale_days must not be negative")
+	}
+	if c.MaxOpenMissionsPerAlchemist < 0 {
+		add("max_open_missions_per_alchemist must not be negative")
+	}
+
+	if c.PasswordPolicy.MinLength < 0 || c.PasswordPolicy.MinLength > maxPasswordMinLength {
+		add("password_policy.min_length must be between 0 and %d", maxPasswordMinLength)
+	}
+	if c.PasswordResetTTLMinutes < 0 {
+		add("password_reset_ttl_minutes must not be negative")
+	}
+	if c.PasswordResetURL != "" {
+		if u, err := url.Parse(c.PasswordResetURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("password_reset_url must be an absolute http(s) URL, got %q", c.PasswordResetURL)
+		}
+	}
+	switch c.Notifier.Type {
+	case "", "log":
+	case "file":
+		if strings.TrimSpace(c.Notifier.Path) == "" {
+			add("notifier.path is required when notifier.type is file")
+		}
+	default:
+		add("notifier.type must be log or file, got %q", c.Notifier.Type)
+	}
+
+	lp := c.LoginProtection
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"max_failures", lp.MaxFailures}, {"window_minutes", lp.WindowMinutes}, {"lockout_minutes", lp.LockoutMinutes},
+		{"ip_max_failures", lp.IPMaxFailures}, {"delay_base_ms", lp.DelayBaseMs}, {"delay_max_ms", lp.DelayMaxMs},
+	} {
+		if f.value < 0 {
+			add("login_protection.%s must not be negative", f.name)
+		}
+	}
+	if lp.DelayBaseMs > 0 && lp.DelayMaxMs > 0 && lp.DelayMaxMs < lp.DelayBaseMs {
+		add("login_protection.delay_max_ms must be >= delay_base_ms")
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "", "text", "json":
+	default:
+		add("log.format must be text or json, got %q", c.Log.Format)
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		add("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.TwoFactor.ChallengeTTLMinutes < 0 {
+		add("two_factor.challenge_ttl_minutes must not be negative")
+	}
+	return p
+}
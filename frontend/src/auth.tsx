@@ -5,6 +5,7 @@ import {
   decodeRole,
   getToken,
 } from "./services/session";
+import { logoutSession } from "./services/api";
 
 //  Tipo extendido de usuario
 export type User = {
@@ -49,9 +50,14 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   }, []);
 
   const logout = () => {
-    clearToken();
-    setUser(null);
-    window.location.href = "/login";
+    // se revoca en el servidor sin esperar; la sesión local se limpia igual
+    logoutSession()
+      .catch(() => {})
+      .finally(() => {
+        clearToken();
+        setUser(null);
+        window.location.href = "/login";
+      });
   };
 
   return (
@@ -38,6 +38,7 @@ export default function AuditsPage() {
               <th align="left">Action</th>
               <th align="left">Entity</th>
               <th align="left">Entity ID</th>
+              <th align="left">Actor</th>
               <th align="left">Created</th>
             </tr>
           </thead>
@@ -49,12 +50,13 @@ export default function AuditsPage() {
                   <td>{a.action}</td>
                   <td>{a.entity}</td>
                   <td>{a.entity_id}</td>
+                  <td>{a.actor_email || "—"}</td>
                   <td>{a.created_at ? new Date(a.created_at).toLocaleString() : "—"}</td>
                 </tr>
               ))
             ) : (
               <tr key="empty">
-                <td colSpan={5} align="center">
+                <td colSpan={6} align="center">
                   No data
                 </td>
               </tr>
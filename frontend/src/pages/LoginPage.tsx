@@ -1,15 +1,39 @@
 // src/pages/LoginPage.tsx
 import { useState } from "react";
 import { Link } from "react-router-dom";
-import { setToken } from "../services/session";
+import { setSession } from "../services/session";
+import { loginSecondFactor, setupTwoFactor, verifyTwoFactor } from "../services/api";
 
 const BASE = "http://localhost:8000";
 
+// credentials → (code si la cuenta tiene 2FA) → (setup y codes si es obligatorio activarlo)
+type Step = "credentials" | "code" | "setup" | "codes";
+
 export default function LoginPage() {
   const [email, setEmail] = useState("");
   const [pwd, setPwd] = useState("");
   const [err, setErr] = useState("");
   const [loading, setLoading] = useState(false);
+  const [step, setStep] = useState<Step>("credentials");
+  const [challenge, setChallenge] = useState("");
+  const [code, setCode] = useState("");
+  const [useRecovery, setUseRecovery] = useState(false);
+  const [secret, setSecret] = useState("");
+  const [otpauthUrl, setOtpauthUrl] = useState("");
+  const [recoveryCodes, setRecoveryCodes] = useState<string[]>([]);
+
+  const finish = async (data: { token: string; refresh_token?: string; two_factor_setup_required?: boolean }) => {
+    setSession(data.token, data.refresh_token);
+    if (data.two_factor_setup_required) {
+      const setup = await setupTwoFactor();
+      setSecret(setup.secret);
+      setOtpauthUrl(setup.otpauth_url);
+      setCode("");
+      setStep("setup");
+      return;
+    }
+    window.location.href = "/";
+  };
 
   const onSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -17,17 +41,32 @@ export default function LoginPage() {
     setLoading(true);
 
     try {
-      const res = await fetch(`${BASE}/auth/login`, {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ email, password: pwd }),
-      });
+      if (step === "credentials") {
+        const res = await fetch(`${BASE}/auth/login`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ email, password: pwd }),
+        });
 
-      if (!res.ok) throw new Error(await res.text());
+        if (!res.ok) throw new Error(await res.text());
 
-      const data = await res.json();
-      setToken(data.token);
-      window.location.href = "/";
+        const data = await res.json();
+        if (data.two_factor_required) {
+          setChallenge(data.challenge_token);
+          setStep("code");
+          return;
+        }
+        await finish(data);
+      } else if (step === "code") {
+        await finish(await loginSecondFactor(challenge, code.trim(), useRecovery));
+      } else if (step === "setup") {
+        const data = await verifyTwoFactor(code.trim());
+        setSession(data.token, data.refresh_token);
+        setRecoveryCodes(data.recovery_codes);
+        setStep("codes");
+      } else {
+        window.location.href = "/";
+      }
     } catch (e: any) {
       setErr(e.message || "Login failed");
     } finally {
@@ -51,29 +90,90 @@ export default function LoginPage() {
         {err && <div className="alert alert--danger">{err}</div>}
 
         <form className="auth-form" onSubmit={onSubmit}>
-          <label className="field">
-            <span>Email</span>
-            <input
-              placeholder="tú@alquimia.io"
-              value={email}
-              onChange={(e) => setEmail(e.target.value)}
-              required
-              type="email"
-              autoFocus
-            />
-          </label>
-          <label className="field">
-            <span>Contraseña</span>
-            <input
-              placeholder="••••••••"
-              type="password"
-              value={pwd}
-              onChange={(e) => setPwd(e.target.value)}
-              required
-            />
-          </label>
+          {step === "credentials" && (
+            <>
+              <label className="field">
+                <span>Email</span>
+                <input
+                  placeholder="tú@alquimia.io"
+                  value={email}
+                  onChange={(e) => setEmail(e.target.value)}
+                  required
+                  type="email"
+                  autoFocus
+                />
+              </label>
+              <label className="field">
+                <span>Contraseña</span>
+                <input
+                  placeholder="••••••••"
+                  type="password"
+                  value={pwd}
+                  onChange={(e) => setPwd(e.target.value)}
+                  required
+                />
+              </label>
+            </>
+          )}
+
+          {step === "code" && (
+            <>
+              <label className="field">
+                <span>{useRecovery ? "Código de recuperación" : "Código de la app autenticadora"}</span>
+                <input
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  placeholder={useRecovery ? "xxxxx-xxxxx" : "123456"}
+                  required
+                  autoFocus
+                  autoComplete="one-time-code"
+                />
+              </label>
+              <button type="button" className="link" onClick={() => setUseRecovery(!useRecovery)}>
+                {useRecovery ? "Usar la app autenticadora" : "Usar un código de recuperación"}
+              </button>
+            </>
+          )}
+
+          {step === "setup" && (
+            <>
+              <p>
+                Tu rol exige verificación en dos pasos. Agrega esta clave en tu app autenticadora
+                (o abre el enlace en el dispositivo) e ingresa el código que muestra.
+              </p>
+              <code>{secret}</code>
+              <a className="link" href={otpauthUrl}>
+                Abrir en la app autenticadora
+              </a>
+              <label className="field">
+                <span>Código</span>
+                <input
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  placeholder="123456"
+                  required
+                  autoFocus
+                  autoComplete="one-time-code"
+                />
+              </label>
+            </>
+          )}
+
+          {step === "codes" && (
+            <>
+              <p>Guarda estos códigos de recuperación; cada uno sirve una sola vez y no se volverán a mostrar.</p>
+              <ul>
+                {recoveryCodes.map((c) => (
+                  <li key={c}>
+                    <code>{c}</code>
+                  </li>
+                ))}
+              </ul>
+            </>
+          )}
+
           <button type="submit" disabled={loading}>
-            {loading ? "Entrando…" : "Entrar"}
+            {loading ? "Entrando…" : step === "codes" ? "Continuar" : step === "credentials" ? "Entrar" : "Verificar"}
           </button>
         </form>
 
@@ -86,4 +186,4 @@ export default function LoginPage() {
       </div>
     </div>
   );
-}
\ No newline at end of file
+}
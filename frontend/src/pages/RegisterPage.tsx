@@ -2,14 +2,12 @@
 import { useState } from "react";
 import { useNavigate, Link } from "react-router-dom";
 import { register } from "../services/api";
-import { setToken } from "../services/session";
-
-type Role = "ALCHEMIST" | "SUPERVISOR";
+import { setSession } from "../services/session";
 
 export default function RegisterPage() {
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
-  const [role, setRole] = useState<Role>("ALCHEMIST");
+  const [invitation, setInvitation] = useState("");
   const [alchemistId, setAlchemistId] = useState<number | "">("");
   const [error, setError] = useState("");
   const [loading, setLoading] = useState(false);
@@ -25,26 +23,14 @@ export default function RegisterPage() {
 
     setLoading(true);
     try {
-      const payload: {
-        email: string;
-        password: string;
-        role: Role;
-        alchemist_id?: number;
-      } = { email: email.trim(), password, role };
-
-      if (role === "ALCHEMIST" && alchemistId !== "") {
-        payload.alchemist_id = Number(alchemistId);
-      }
-
       const res = await register(
-        payload.email,
-        payload.password,
-        payload.role,
-        payload.alchemist_id,
+        email.trim(),
+        password,
+        alchemistId !== "" ? Number(alchemistId) : undefined,
+        invitation.trim() || undefined,
       );
 
-      setToken(res.token);
-      localStorage.setItem("jwt", res.token);
+      setSession(res.token, res.refresh_token);
 
       nav("/");
     } catch (err: any) {
@@ -62,7 +48,7 @@ export default function RegisterPage() {
           <div>
             <h1 className="auth-title">Crear cuenta</h1>
             <p className="auth-subtitle">
-              Diseña un perfil para un nuevo alquimista o supervisor.
+              Crea tu perfil de alquimista. Los supervisores se registran con una invitación.
             </p>
           </div>
         </div>
@@ -88,32 +74,31 @@ export default function RegisterPage() {
               value={password}
               onChange={(e) => setPassword(e.target.value)}
               required
-              minLength={6}
+              minLength={8}
             />
           </label>
 
           <label className="field">
-            <span>Rol</span>
-            <select value={role} onChange={(e) => setRole(e.target.value as Role)}>
-              <option value="ALCHEMIST">Alchemist</option>
-              <option value="SUPERVISOR">Supervisor</option>
-            </select>
+            <span>Alchemist ID (opcional)</span>
+            <input
+              type="number"
+              value={alchemistId}
+              onChange={(e) =>
+                setAlchemistId(e.target.value ? Number(e.target.value) : "")
+              }
+              placeholder="Si ya existe un alquimista, relaciónalo aquí"
+              min={1}
+            />
           </label>
 
-          {role === "ALCHEMIST" && (
-            <label className="field">
-              <span>Alchemist ID (opcional)</span>
-              <input
-                type="number"
-                value={alchemistId}
-                onChange={(e) =>
-                  setAlchemistId(e.target.value ? Number(e.target.value) : "")
-                }
-                placeholder="Si ya existe un alquimista, relaciónalo aquí"
-                min={1}
-              />
-            </label>
-          )}
+          <label className="field">
+            <span>Código de invitación (opcional)</span>
+            <input
+              value={invitation}
+              onChange={(e) => setInvitation(e.target.value)}
+              placeholder="Entregado por un supervisor"
+            />
+          </label>
 
           <button type="submit" disabled={loading}>
             {loading ? "Creando…" : "Registrarme"}
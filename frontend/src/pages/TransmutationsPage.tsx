@@ -23,10 +23,12 @@ type WsEnvelope =
         | "transmutation:started"
         | "transmutation:updated"
         | "transmutation:completed"
-        | "transmutation:cancelled";
+        | "transmutation:cancelled"
+        | "transmutation:failed";
       data: Transmutation;
+      seq?: number;
     }
-  | { type: string; data: any };
+  | { type: string; data: any; seq?: number };
 
 export default function TransmutationsPage() {
   const [list, setList] = useState<Transmutation[]>([]);
@@ -87,19 +89,33 @@ export default function TransmutationsPage() {
   useEffect(() => {
     let ws: WebSocket | null = null;
     let reconnectTimer: number | undefined;
+    // último seq recibido, para recuperar lo perdido al reconectar
+    let lastSeq = 0;
 
     const connect = () => {
       ws = new WebSocket(wsUrl);
       wsRef.current = ws;
 
+      ws.onopen = () => {
+        const token = localStorage.getItem("jwt") || "";
+        ws?.send(JSON.stringify({ type: "auth", token, resume_from: lastSeq }));
+      };
+
       ws.onmessage = (evt) => {
         try {
           const msg: WsEnvelope = JSON.parse(evt.data);
+          if (msg.seq) lastSeq = msg.seq;
+          if (msg.type === "replay:gap") {
+            // el servidor ya no tiene los eventos perdidos: recargar todo
+            load();
+            return;
+          }
           if (
             msg.type === "transmutation:started" ||
             msg.type === "transmutation:updated" ||
             msg.type === "transmutation:completed" ||
-            msg.type === "transmutation:cancelled"
+            msg.type === "transmutation:cancelled" ||
+            msg.type === "transmutation:failed"
           ) {
             setList((prev) => {
               const t = msg.data;
@@ -1,4 +1,5 @@
 // src/services/api.ts
+import { clearToken, getRefreshToken, setSession } from "./session";
 
 
 export interface Alchemist {
@@ -38,6 +39,7 @@ export interface Transmutation {
   alchemist?: Alchemist;
   estimated_cost?: number;
   estimated_duration_seconds?: number;
+  failure_reason?: string;
 }
 
 export interface TransmutationMaterialInput {
@@ -79,6 +81,11 @@ export interface Audit {
   entity: string;
   entity_id: number;
   created_at?: string;
+  actor_user_id?: number;
+  actor_email?: string;
+  actor_role?: string;
+  source_ip?: string;
+  request_id?: string;
 }
 
 
@@ -90,7 +97,25 @@ function getToken() {
   return localStorage.getItem("jwt") || "";
 }
 
-async function http<T>(url: string, init?: RequestInit): Promise<T> {
+// refreshSession canjea el refresh token guardado; si falla se cierra la sesión
+async function refreshSession(): Promise<boolean> {
+  const refresh = getRefreshToken();
+  if (!refresh) return false;
+  const res = await fetch(`${BASE}/auth/refresh`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refresh_token: refresh }),
+  });
+  if (!res.ok) {
+    clearToken();
+    return false;
+  }
+  const data: AuthResponse = await res.json();
+  setSession(data.token, data.refresh_token);
+  return true;
+}
+
+async function http<T>(url: string, init?: RequestInit, retried = false): Promise<T> {
   const res = await fetch(url, {
     ...init,
     headers: {
@@ -100,6 +125,11 @@ async function http<T>(url: string, init?: RequestInit): Promise<T> {
     },
   });
 
+  // access token vencido: se rota el refresh y se reintenta una vez
+  if (res.status === 401 && !retried && !url.includes("/auth/") && (await refreshSession())) {
+    return http<T>(url, init, true);
+  }
+
 
   if (!res.ok) {
     const text = await res.text();
@@ -118,6 +148,43 @@ async function http<T>(url: string, init?: RequestInit): Promise<T> {
 
 export interface AuthResponse {
   token: string;
+  refresh_token?: string;
+  token_type?: string;
+  expires_in?: number;
+  // SUPERVISOR sin 2FA cuando es obligatorio: solo puede activarlo
+  two_factor_setup_required?: boolean;
+}
+
+// respuesta de /auth/login cuando la cuenta tiene 2FA
+export interface TwoFactorChallenge {
+  two_factor_required: true;
+  challenge_token: string;
+  expires_in: number;
+}
+
+export function loginSecondFactor(challenge_token: string, code: string, recovery = false) {
+  return http<AuthResponse>(`${BASE}/auth/login/2fa`, {
+    method: "POST",
+    body: JSON.stringify(recovery ? { challenge_token, recovery_code: code } : { challenge_token, code }),
+  });
+}
+
+export function setupTwoFactor() {
+  return http<{ secret: string; otpauth_url: string }>(`${BASE}/auth/2fa/setup`, { method: "POST" });
+}
+
+export function verifyTwoFactor(code: string) {
+  return http<AuthResponse & { recovery_codes: string[] }>(`${BASE}/auth/2fa/verify`, {
+    method: "POST",
+    body: JSON.stringify({ code }),
+  });
+}
+
+export function logoutSession() {
+  return http<void>(`${BASE}/auth/logout`, {
+    method: "POST",
+    body: JSON.stringify({ refresh_token: getRefreshToken() }),
+  });
 }
 
 export function login(email: string, password: string) {
@@ -127,10 +194,11 @@ export function login(email: string, password: string) {
   });
 }
 
-export function register(email: string, password: string, role: "ALCHEMIST" | "SUPERVISOR", alchemist_id?: number) {
+// sin invitación se crea un ALCHEMIST; el rol de la invitación lo decide el servidor
+export function register(email: string, password: string, alchemist_id?: number, invitation_token?: string) {
   return http<AuthResponse>(`${BASE}/auth/register`, {
     method: "POST",
-    body: JSON.stringify({ email, password, role, alchemist_id }),
+    body: JSON.stringify({ email, password, alchemist_id, invitation_token }),
   });
 }
 
@@ -212,6 +280,12 @@ export const updateMission = (id: number, data: Partial<Mission>) =>
     body: JSON.stringify(data),
   });
 
+export const updateMissionStatus = (id: number, status: string) =>
+  http<Mission>(`${BASE}/missions/${id}/status`, {
+    method: "PATCH",
+    body: JSON.stringify({ status }),
+  });
+
 export const deleteMission = (id: number) =>
   fetch(`${BASE}/missions/${id}`, {
     method: "DELETE",
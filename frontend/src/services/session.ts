@@ -15,8 +15,17 @@ export function setToken(t: string) {
   localStorage.setItem("jwt", t);
   notifyAuthChange();
 }
+export function getRefreshToken() {
+  return localStorage.getItem("refresh_token") || "";
+}
+// guarda el par que devuelven /auth/login, /auth/register y /auth/refresh
+export function setSession(token: string, refreshToken?: string) {
+  if (refreshToken) localStorage.setItem("refresh_token", refreshToken);
+  setToken(token);
+}
 export function clearToken() {
   localStorage.removeItem("jwt");
+  localStorage.removeItem("refresh_token");
   notifyAuthChange();
 }
 export function decodeRole(): "ALCHEMIST" | "SUPERVISOR" | null {
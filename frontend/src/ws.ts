@@ -1,14 +1,20 @@
 // src/ws.ts
-export type WSMessage<T = any> = { type: string; data: T };
+export type WSMessage<T = any> = { type: string; data: T; seq?: number; ts?: string };
+
+// último seq recibido; al reconectar se pide lo que se perdió con resume_from
+let lastSeq = 0;
 
 export function openWS(onMessage: (msg: WSMessage) => void): WebSocket {
   // Asume backend en http://localhost:8000
-  const url = (location.protocol === "https:" ? "wss://" : "ws://") + "localhost:8000/ws";
+  const token = localStorage.getItem("jwt") || "";
+  const url = (location.protocol === "https:" ? "wss://" : "ws://") + "localhost:8000/ws?token=" + encodeURIComponent(token) +
+    (lastSeq > 0 ? "&resume_from=" + lastSeq : "");
   const ws = new WebSocket(url);
 
   ws.onmessage = (ev) => {
     try {
-      const parsed = JSON.parse(ev.data);
+      const parsed: WSMessage = JSON.parse(ev.data);
+      if (parsed.seq) lastSeq = parsed.seq;
       onMessage(parsed);
     } catch {
     }
@@ -1,35 +1,192 @@
+// Package logger configura log/slog para el servidor y agrega el middleware
+// que asigna X-Request-ID y escribe una línea de acceso por request.
 package logger
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength evita que un cliente llene los logs con un id enorme.
+const maxRequestIDLength = 128
+
+type ctxKey struct{}
+
 type Logger struct {
-	*log.Logger
+	*slog.Logger
 }
 
+// New crea un logger en formato "text" (por defecto) o "json" con el nivel
+// indicado ("debug", "info", "warn" o "error").
+func New(format, level string, w io.Writer) *Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+	var h slog.Handler
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return &Logger{slog.New(h)}
+}
+
+// NewLogger es el logger de arranque, antes de leer la configuración.
 func NewLogger() *Logger {
-	return &Logger{log.New(log.Writer(), "", 0)}
+	return New("text", "info", os.Stderr)
+}
+
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Fatal registra el error y termina el proceso.
+func (l *Logger) Fatal(err error) {
+	l.Error("fatal", "error", err)
+	os.Exit(1)
+}
+
+// FromContext agrega el request_id del contexto, si lo hay.
+func (l *Logger) FromContext(ctx context.Context) *Logger {
+	if id := RequestID(ctx); id != "" {
+		return &Logger{l.With("request_id", id)}
+	}
+	return l
+}
+
+// WithRequestID guarda el id en el contexto; lo usan las tareas en segundo
+// plano que nacen de un request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
 }
 
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// NewRequestID genera un id aleatorio de 16 bytes en hex.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestLogger toma X-Request-ID (o genera uno), lo devuelve en la respuesta
+// y lo deja en el contexto. Al terminar escribe la línea de acceso con
+// status, bytes y latencia.
 func (l *Logger) RequestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		now := time.Now()
-		log.Printf("\033[44m %s \033[0m | PATH: \033[33m\"%s\"\033[0m | TIME: %v", r.Method, r.URL.Path, now.Format("2006-01-02 15:04:05"))
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		id := strings.TrimSpace(r.Header.Get(RequestIDHeader))
+		if id == "" || len(id) > maxRequestIDLength {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(WithRequestID(r.Context(), id)))
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if rec.err != nil {
+			attrs = append(attrs, slog.String("error", rec.err.Error()))
+		}
+		l.LogAttrs(r.Context(), level, "request", attrs...)
 	})
 }
 
-func (l *Logger) Info(status int, path string, start time.Time) {
-	l.Printf("\033[42m %d \033[0m | PATH: \033[33m\"%s\"\033[0m | DURATION: \033[42m %v \033[0m", status, path, time.Since(start))
+// RecordError adjunta la causa de un error a la línea de acceso. Devuelve
+// false si w no pasó por RequestLogger.
+func RecordError(w http.ResponseWriter, err error) bool {
+	for {
+		switch rw := w.(type) {
+		case *responseRecorder:
+			rw.err = err
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return false
+		}
+	}
 }
 
-func (l *Logger) Error(status int, path string, err error) {
-	l.Printf("\033[41m %d \033[0m | PATH: \033[33m\"%s\"\033[0m | ERROR: \033[31m %v \033[0m", status, path, err)
+// responseRecorder mide la respuesta. Implementa Flusher y Hijacker para que
+// SSE y WebSocket sigan funcionando detrás del middleware.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	err         error
+	wroteHeader bool
 }
 
-func (l *Logger) Fatal(err error) {
-	l.Fatalf("\033[41m FATAL \033[0m | ERROR: \033[31m %v \033[0m", err)
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	// tras el upgrade el status efectivo es 101
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
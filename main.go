@@ -2,9 +2,13 @@ package main
 
 import (
 	"backend-avanzada/server"
+	"flag"
 )
 
 func main() {
-	s := server.NewServer()
+	configPath := flag.String("config", "", "ruta de config.json (por defecto config/config.json)")
+	flag.Parse()
+
+	s := server.NewServer(*configPath)
 	s.StartServer()
 }
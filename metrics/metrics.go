@@ -0,0 +1,248 @@
+// Package metrics implementa lo mínimo del formato de texto de Prometheus:
+// contadores e histogramas con etiquetas y gauges que se calculan al leerlos.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets son los límites (en segundos) de los histogramas de latencia.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry agrupa las métricas y las publica en el orden en que se registraron.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write escribe todas las métricas en formato de texto.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		r.Write(w)
+	})
+}
+
+// CounterVec es un contador por combinación de etiquetas.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	r.add(c)
+	return c
+}
+
+// Inc suma uno; labelValues va en el mismo orden que las etiquetas.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatValue(c.values[key]))
+	}
+}
+
+// HistogramVec acumula observaciones por combinación de etiquetas.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // por bucket, sin acumular
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: b, series: map[string]*histogram{}}
+	r.add(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// funcMetric se calcula al leer las métricas. fn devuelve el valor por
+// valor de etiqueta (clave vacía si no hay etiqueta).
+type funcMetric struct {
+	name, help, typ string
+	label           string
+	fn              func() map[string]float64
+}
+
+// NewGaugeFunc registra un gauge sin etiquetas.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.add(&funcMetric{name: name, help: help, typ: "gauge", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// NewGaugeVecFunc registra un gauge con una etiqueta; si fn devuelve nil
+// (p. ej. falló la consulta) la métrica sale sin series.
+func (r *Registry) NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.add(&funcMetric{name: name, help: help, typ: "gauge", label: label, fn: fn})
+}
+
+// NewCounterFunc publica como contador un valor que se lleva en otro lado.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.add(&funcMetric{name: name, help: help, typ: "counter", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+func (g *funcMetric) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, g.typ)
+	values := g.fn()
+	for _, v := range sortedKeys(values) {
+		key := ""
+		if g.label != "" {
+			key = labelKey([]string{g.label}, []string{v})
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, key, formatValue(values[v]))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, typ)
+}
+
+// labelKey arma {a="x",b="y"}; los valores faltantes quedan vacíos.
+func labelKey(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(v))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func withLabel(key, label, value string) string {
+	pair := label + `="` + escapeLabel(value) + `"`
+	if key == "" {
+		return "{" + pair + "}"
+	}
+	return strings.TrimSuffix(key, "}") + "," + pair + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(r *Registry) string {
+	var b strings.Builder
+	r.Write(&b)
+	return b.String()
+}
+
+func TestCounterVecFormat(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("app_requests_total", "Requests con \\ y\nsalto.", "method", "path")
+	c.Inc("GET", `/a"b\c`)
+	c.Add(2.5, "POST", "línea\nnueva")
+	c.Inc("GET", `/a"b\c`)
+	c.Inc("DELETE") // sin path: la etiqueta queda vacía
+
+	want := `# HELP app_requests_total Requests con \\ y\nsalto.
+# TYPE app_requests_total counter
+app_requests_total{method="DELETE",path=""} 1
+app_requests_total{method="GET",path="/a\"b\\c"} 2
+app_requests_total{method="POST",path="línea\nnueva"} 2.5
+`
+	if got := render(r); got != want {
+		t.Errorf("salida:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramVecFormat(t *testing.T) {
+	r := NewRegistry()
+	// los límites llegan desordenados: se ordenan al registrar
+	h := r.NewHistogramVec("app_latency_seconds", "Latencia.", []float64{1, 0.1, 0.5}, "route")
+	h.Observe(0.05, "/x")
+	h.Observe(0.1, "/x") // igual al límite: cuenta en le="0.1"
+	h.Observe(0.7, "/x")
+	h.Observe(3, "/x") // por encima de todos: solo en +Inf
+
+	want := `# HELP app_latency_seconds Latencia.
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{route="/x",le="0.1"} 2
+app_latency_seconds_bucket{route="/x",le="0.5"} 2
+app_latency_seconds_bucket{route="/x",le="1"} 3
+app_latency_seconds_bucket{route="/x",le="+Inf"} 4
+app_latency_seconds_sum{route="/x"} 3.85
+app_latency_seconds_count{route="/x"} 4
+`
+	if got := render(r); got != want {
+		t.Errorf("salida:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("job_seconds", "Duración.", []float64{1})
+	h.Observe(2)
+
+	want := `# HELP job_seconds Duración.
+# TYPE job_seconds histogram
+job_seconds_bucket{le="1"} 0
+job_seconds_bucket{le="+Inf"} 1
+job_seconds_sum 2
+job_seconds_count 1
+`
+	if got := render(r); got != want {
+		t.Errorf("salida:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFuncMetricsFormat(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("queue_size", "Tareas.", func() float64 { return 3 })
+	r.NewGaugeVecFunc("items", "Por estado.", "status", func() map[string]float64 {
+		return map[string]float64{"b": 2, `a"x`: 1}
+	})
+	r.NewGaugeVecFunc("broken", "Falla la consulta.", "status", func() map[string]float64 { return nil })
+	r.NewCounterFunc("dropped_total", "Descartados.", func() float64 { return math.Inf(1) })
+
+	want := `# HELP queue_size Tareas.
+# TYPE queue_size gauge
+queue_size 3
+# HELP items Por estado.
+# TYPE items gauge
+items{status="a\"x"} 1
+items{status="b"} 2
+# HELP broken Falla la consulta.
+# TYPE broken gauge
+# HELP dropped_total Descartados.
+# TYPE dropped_total counter
+dropped_total +Inf
+`
+	if got := render(r); got != want {
+		t.Errorf("salida:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandlerContentType(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("hits_total", "Hits.").Inc()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != contentType {
+		t.Errorf("Content-Type = %q, want %q", got, contentType)
+	}
+	if !strings.Contains(w.Body.String(), "hits_total 1\n") {
+		t.Errorf("cuerpo sin la serie: %q", w.Body.String())
+	}
+}
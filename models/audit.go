@@ -12,15 +12,32 @@ type Audit struct {
 	Entity      string
 	EntityID    uint
 	Description string
+
+	// Quién originó el registro; las tareas internas usan el actor SYSTEM
+	ActorUserID *uint  `gorm:"index"`
+	ActorEmail  string `gorm:"index"`
+	ActorRole   string
+	SourceIP    string
+	RequestID   string `gorm:"index"`
 }
 
 func (a *Audit) ToResponseDto() *api.AuditResponseDto {
+	var actorID *int
+	if a.ActorUserID != nil {
+		v := int(*a.ActorUserID)
+		actorID = &v
+	}
 	return &api.AuditResponseDto{
-		ID:          int(a.ID),
-		Accion:      a.Action,
-		Entidad:     a.Entity,
-		EntidadID:   int(a.EntityID),
-		Descripcion: a.Description,
-		Fecha:       a.CreatedAt.String(),
+		ID:             int(a.ID),
+		Accion:         a.Action,
+		Entidad:        a.Entity,
+		EntidadID:      int(a.EntityID),
+		Descripcion:    a.Description,
+		Fecha:          a.CreatedAt.String(),
+		ActorUsuarioID: actorID,
+		ActorEmail:     a.ActorEmail,
+		ActorRol:       a.ActorRole,
+		IPOrigen:       a.SourceIP,
+		SolicitudID:    a.RequestID,
 	}
 }
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"backend-avanzada/api"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invitation permite registrarse con un rol distinto de ALCHEMIST. Solo se
+// guarda el hash del token y se puede usar una vez.
+type Invitation struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex"`
+	// Email vacío = cualquier email puede usarla
+	Email           string
+	Role            string
+	AlchemistID     *uint
+	ExpiresAt       time.Time
+	UsedAt          *time.Time
+	UsedByUserID    *uint
+	CreatedByUserID *uint
+}
+
+func (i *Invitation) ToResponseDto() *api.InvitationResponseDto {
+	var alchemistID *int
+	if i.AlchemistID != nil {
+		v := int(*i.AlchemistID)
+		alchemistID = &v
+	}
+	return &api.InvitationResponseDto{
+		ID:           int(i.ID),
+		Email:        i.Email,
+		Rol:          i.Role,
+		AlquimistaID: alchemistID,
+		ExpiraEn:     i.ExpiresAt.String(),
+		Usada:        i.UsedAt != nil,
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken guarda solo el hash del token. Cada login abre una familia
+// nueva; al rotar, el token usado se marca revocado y el nuevo hereda FamilyID.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	FamilyID  string `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	// RotatedAt indica que se canjeó por uno nuevo; volver a presentarlo es un reuso
+	RotatedAt *time.Time
+
+	// access token emitido junto con este refresh, para revocarlo con la familia
+	AccessJTI       string
+	AccessExpiresAt time.Time
+}
+
+// RevokedToken es la lista de access tokens (por jti) que ya no se aceptan
+// aunque su firma siga vigente. Se purga al pasar ExpiresAt.
+type RevokedToken struct {
+	ID        uint   `gorm:"primarykey"`
+	JTI       string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// PasswordResetToken es un token de un solo uso para restablecer la contraseña.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
@@ -15,6 +15,12 @@ type Transmutation struct {
 	Alchemist              *Alchemist
 	EstimatedCost          float64
 	EstimatedDurationTotal int
+	Complexity             string
+	RiskLevel              string
+	CatalystQuality        int
+	FailureReason          string
+	Materials              []TransmutationMaterial
+	StockReserved          bool
 }
 
 func (t *Transmutation) ToResponseDto(includeAlchemist bool) *api.TransmutationResponseDto {
@@ -26,6 +32,16 @@ func (t *Transmutation) ToResponseDto(includeAlchemist bool) *api.TransmutationR
 		CreatedAt:              t.CreatedAt.Format(time.RFC3339),
 		EstimatedCost:          t.EstimatedCost,
 		EstimatedDurationTotal: t.EstimatedDurationTotal,
+		Complexity:             t.Complexity,
+		RiskLevel:              t.RiskLevel,
+		CatalystQuality:        t.CatalystQuality,
+		FailureReason:          t.FailureReason,
+	}
+	if len(t.Materials) > 0 {
+		dto.Materials = make([]api.TransmutationSimulationMaterialBreakdownDto, 0, len(t.Materials))
+		for i := range t.Materials {
+			dto.Materials = append(dto.Materials, t.Materials[i].ToResponseDto())
+		}
 	}
 	if includeAlchemist && t.Alchemist != nil {
 		dto.Alchemist = t.Alchemist.ToResponseDto()
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransmutationJob persiste la tarea programada de una transmutación en curso
+// para poder retomarla si el proceso se reinicia.
+type TransmutationJob struct {
+	gorm.Model
+	TransmutationID uint `gorm:"uniqueIndex"`
+	StartedAt       time.Time
+	DueAt           time.Time
+	Attempts        int
+	LastError       string
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"backend-avanzada/api"
+
+	"gorm.io/gorm"
+)
+
+// TransmutationMaterial guarda la lista de materiales solicitada con cada
+// transmutación, con el costo unitario vigente al momento de la solicitud.
+type TransmutationMaterial struct {
+	gorm.Model
+	TransmutationID uint `gorm:"index"`
+	MaterialID      uint `gorm:"index"`
+	Material        *Material
+	Quantity        float64
+	UnitCost        float64
+	Subtotal        float64
+}
+
+func (tm *TransmutationMaterial) ToResponseDto() api.TransmutationSimulationMaterialBreakdownDto {
+	dto := api.TransmutationSimulationMaterialBreakdownDto{
+		MaterialID: int(tm.MaterialID),
+		Quantity:   tm.Quantity,
+		UnitCost:   tm.UnitCost,
+		Subtotal:   tm.Subtotal,
+	}
+	if tm.Material != nil {
+		dto.Name = tm.Material.Name
+	}
+	return dto
+}
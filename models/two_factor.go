@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode es un código de un solo uso para entrar sin la app TOTP. Solo
+// se guarda el hash.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"index"`
+	UsedAt   *time.Time
+}
+
+// LoginChallenge es el paso intermedio del login con 2FA: la contraseña ya se
+// validó y falta el código. Vive pocos minutos y admite pocos intentos.
+type LoginChallenge struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	Attempts  int
+}
@@ -1,11 +1,51 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"backend-avanzada/api"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
 	Email        string `gorm:"uniqueIndex"`
 	PasswordHash string
 	Role         string // "ALCHEMIST" o "SUPERVISOR"
-	AlchemistID  *uint
+	// AlchemistID es único: una ficha de alquimista pertenece a un solo usuario
+	// (los NULL no chocan entre sí)
+	AlchemistID *uint `gorm:"uniqueIndex"`
+	// Disabled bloquea el login y los tokens ya emitidos sin borrar la cuenta
+	Disabled bool `gorm:"index"`
+	// LockedUntil lo fija el bloqueo por intentos fallidos de login
+	LockedUntil *time.Time
+
+	// TOTPSecret (base32) existe desde /auth/2fa/setup; el segundo factor se
+	// exige recién cuando TOTPEnabled, tras confirmar un código.
+	TOTPSecret  string
+	TOTPEnabled bool
+	// último paso de 30 s aceptado, para no aceptar el mismo código dos veces
+	TOTPLastStep int64
+}
+
+func (u *User) ToResponseDto() *api.UserResponseDto {
+	var alchemistID *int
+	if u.AlchemistID != nil {
+		v := int(*u.AlchemistID)
+		alchemistID = &v
+	}
+	var lockedUntil string
+	if u.LockedUntil != nil && u.LockedUntil.After(time.Now()) {
+		lockedUntil = u.LockedUntil.String()
+	}
+	return &api.UserResponseDto{
+		ID:             int(u.ID),
+		Email:          u.Email,
+		Rol:            u.Role,
+		AlquimistaID:   alchemistID,
+		Deshabilitado:  u.Disabled,
+		BloqueadoHasta: lockedUntil,
+		DobleFactor:    u.TOTPEnabled,
+		Fecha:          u.CreatedAt.String(),
+	}
 }
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"backend-avanzada/api"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook es una suscripción externa a los eventos que publica notify.
+type Webhook struct {
+	gorm.Model
+	URL string
+	// Events guarda los tópicos separados por coma, con la misma sintaxis que /ws
+	Events string
+	Secret string
+	Active bool
+}
+
+func (w *Webhook) EventList() []string {
+	var list []string
+	for _, e := range strings.Split(w.Events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// ToResponseDto no incluye el secreto; solo se devuelve al crear el webhook.
+func (w *Webhook) ToResponseDto() *api.WebhookResponseDto {
+	return &api.WebhookResponseDto{
+		ID:      int(w.ID),
+		URL:     w.URL,
+		Eventos: w.EventList(),
+		Activo:  w.Active,
+		Fecha:   w.CreatedAt.String(),
+	}
+}
+
+// WebhookDelivery registra cada intento de entrega; los reintentos de un mismo
+// evento comparten DeliveryID. NextAttemptAt queda cargado en el último intento
+// fallido mientras falte reintentarlo, así sobrevive a un reinicio.
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID     uint   `gorm:"index"`
+	DeliveryID    string `gorm:"index"`
+	Event         string
+	Payload       string
+	Attempt       int
+	StatusCode    int
+	Success       bool
+	Error         string
+	DurationMs    int64
+	NextAttemptAt *time.Time `gorm:"index"`
+}
+
+func (d *WebhookDelivery) ToResponseDto() *api.WebhookDeliveryResponseDto {
+	dto := &api.WebhookDeliveryResponseDto{
+		ID:         int(d.ID),
+		WebhookID:  int(d.WebhookID),
+		EntregaID:  d.DeliveryID,
+		Evento:     d.Event,
+		Intento:    d.Attempt,
+		CodigoHTTP: d.StatusCode,
+		Exitosa:    d.Success,
+		Error:      d.Error,
+		DuracionMs: d.DurationMs,
+		Fecha:      d.CreatedAt.String(),
+	}
+	if d.NextAttemptAt != nil {
+		dto.ProximoIntento = d.NextAttemptAt.String()
+	}
+	return dto
+}
@@ -0,0 +1,77 @@
+// Package notifier entrega mensajes a los usuarios (por ahora, enlaces de
+// recuperación de contraseña). Las implementaciones incluidas son para uso
+// local; un envío real por correo solo necesita implementar Notifier.
+package notifier
+
+import (
+	"backend-avanzada/config"
+	"backend-avanzada/logger"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New elige la implementación según config.notifier.type ("log" por defecto).
+func New(cfg config.NotifierConfig, l *logger.Logger) (Notifier, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case "", "log":
+		return &LogNotifier{logger: l}, nil
+	case "file":
+		if strings.TrimSpace(cfg.Path) == "" {
+			return nil, fmt.Errorf("notifier file requires a path")
+		}
+		return &FileNotifier{path: cfg.Path}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+	}
+}
+
+// LogNotifier escribe el mensaje en el log del servidor.
+type LogNotifier struct {
+	logger *logger.Logger
+}
+
+func (n *LogNotifier) Send(_ context.Context, msg Message) error {
+	n.logger.Info("notificación", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// FileNotifier agrega cada mensaje como una línea JSON al archivo indicado.
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Send(_ context.Context, msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
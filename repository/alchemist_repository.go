@@ -15,6 +15,10 @@ func (r *AlchemistRepository) FindAll() ([]*models.Alchemist, error) {
 	var list []*models.Alchemist
 	return list, r.db.Find(&list).Error
 }
+func (r *AlchemistRepository) FindPage(spec QuerySpec) (*Page[models.Alchemist], error) {
+	return findPage[models.Alchemist](r.db, spec)
+}
+
 func (r *AlchemistRepository) FindById(id int) (*models.Alchemist, error) {
 	var m models.Alchemist
 	err := r.db.Where("id = ?", id).First(&m).Error
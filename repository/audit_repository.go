@@ -15,6 +15,11 @@ func (r *AuditRepository) FindAll() ([]*models.Audit, error) {
 	var list []*models.Audit
 	return list, r.db.Find(&list).Error
 }
+
+func (r *AuditRepository) FindPage(spec QuerySpec) (*Page[models.Audit], error) {
+	return findPage[models.Audit](r.db, spec)
+}
+
 func (r *AuditRepository) FindById(id int) (*models.Audit, error) {
 	var m models.Audit
 	err := r.db.Where("id = ?", id).First(&m).Error
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type InvitationRepository struct{ db *gorm.DB }
+
+func NewInvitationRepository(db *gorm.DB) *InvitationRepository {
+	return &InvitationRepository{db}
+}
+
+func (r *InvitationRepository) FindByHash(hash string) (*models.Invitation, error) {
+	var i models.Invitation
+	err := r.db.Where("token_hash = ?", hash).First(&i).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &i, err
+}
+
+func (r *InvitationRepository) Save(i *models.Invitation) (*models.Invitation, error) {
+	return i, r.db.Save(i).Error
+}
+
+// Consume marca la invitación como usada solo si nadie la usó antes.
+func (r *InvitationRepository) Consume(id, userID uint, at time.Time) (bool, error) {
+	res := r.db.Model(&models.Invitation{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Updates(map[string]interface{}{"used_at": at, "used_by_user_id": userID})
+	return res.RowsAffected == 1, res.Error
+}
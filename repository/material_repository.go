@@ -15,6 +15,10 @@ func (r *MaterialRepository) FindAll() ([]*models.Material, error) {
 	var list []*models.Material
 	return list, r.db.Find(&list).Error
 }
+func (r *MaterialRepository) FindPage(spec QuerySpec) (*Page[models.Material], error) {
+	return findPage[models.Material](r.db, spec)
+}
+
 func (r *MaterialRepository) FindById(id int) (*models.Material, error) {
 	var m models.Material
 	err := r.db.Where("id = ?", id).First(&m).Error
@@ -7,8 +7,11 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrOpenMissionLimit = errors.New("open mission limit reached")
+
 type MissionRepository struct{ db *gorm.DB }
 
 func NewMissionRepository(db *gorm.DB) *MissionRepository { return &MissionRepository{db} }
@@ -17,6 +20,10 @@ func (r *MissionRepository) FindAll() ([]*models.Mission, error) {
 	var list []*models.Mission
 	return list, r.db.Preload("AssignedTo").Find(&list).Error
 }
+func (r *MissionRepository) FindPage(spec QuerySpec) (*Page[models.Mission], error) {
+	return findPage[models.Mission](r.db, spec, "AssignedTo")
+}
+
 func (r *MissionRepository) FindById(id int) (*models.Mission, error) {
 	var m models.Mission
 	err := r.db.Preload("AssignedTo").Where("id = ?", id).First(&m).Error
@@ -28,6 +35,61 @@ func (r *MissionRepository) FindById(id int) (*models.Mission, error) {
 func (r *MissionRepository) Save(m *models.Mission) (*models.Mission, error) {
 	return m, r.db.Save(m).Error
 }
+
+// UpdateFrom guarda título, descripción, estado y asignado solo si el estado
+// sigue siendo from. Devuelve false si otro request lo cambió antes.
+func (r *MissionRepository) UpdateFrom(m *models.Mission, from string) (bool, error) {
+	return updateMissionFrom(r.db, m, from)
+}
+
+func updateMissionFrom(db *gorm.DB, m *models.Mission, from string) (bool, error) {
+	res := db.Model(m).Where("status = ?", from).Updates(map[string]interface{}{
+		"title":          m.Title,
+		"description":    m.Description,
+		"status":         m.Status,
+		"assigned_to_id": m.AssignedToID,
+	})
+	return res.RowsAffected == 1, res.Error
+}
+
+// SaveWithinLimit crea la misión (si no tiene id) o la edita como UpdateFrom,
+// solo si su alquimista asignado tiene menos de limit misiones abiertas sin
+// contar esta. Como TransmutationRepository.CreateWithinLimit, el conteo y la
+// escritura van en la misma transacción con la fila del alquimista bloqueada.
+// Devuelve las abiertas que encontró y si la edición se aplicó.
+func (r *MissionRepository) SaveWithinLimit(m *models.Mission, from string, limit int64, closedStatuses []string) (int64, bool, error) {
+	var open int64
+	applied := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var alch models.Alchemist
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&alch, *m.AssignedToID).Error; err != nil {
+			return err
+		}
+		if err := openMissionsQuery(tx, *m.AssignedToID, closedStatuses, m.ID).Count(&open).Error; err != nil {
+			return err
+		}
+		if open >= limit {
+			return ErrOpenMissionLimit
+		}
+		if m.ID == 0 {
+			applied = true
+			return tx.Create(m).Error
+		}
+		var err error
+		applied, err = updateMissionFrom(tx, m, from)
+		return err
+	})
+	return open, applied, err
+}
+
+// UpdateStatusFrom cambia solo el estado, y solo si sigue siendo from.
+func (r *MissionRepository) UpdateStatusFrom(id uint, from, to string) (bool, error) {
+	res := r.db.Model(&models.Mission{}).
+		Where("id = ? AND status = ?", id, from).
+		Update("status", to)
+	return res.RowsAffected == 1, res.Error
+}
+
 func (r *MissionRepository) Delete(m *models.Mission) error {
 	return r.db.Delete(m).Error
 }
@@ -50,3 +112,20 @@ func (r *MissionRepository) FindStale(before time.Time, closedStatuses []string)
 	}
 	return list, nil
 }
+
+// openMissionsQuery selecciona las misiones asignadas al alquimista que no
+// están en closedStatuses, sin contar excludeID (la misión que se está editando).
+func openMissionsQuery(db *gorm.DB, alchemistID uint, closedStatuses []string, excludeID uint) *gorm.DB {
+	query := db.Model(&models.Mission{}).Where("assigned_to_id = ?", alchemistID)
+	if len(closedStatuses) > 0 {
+		upper := make([]string, 0, len(closedStatuses))
+		for _, st := range closedStatuses {
+			upper = append(upper, strings.ToUpper(st))
+		}
+		query = query.Where("UPPER(status) NOT IN ?", upper)
+	}
+	if excludeID > 0 {
+		query = query.Where("id <> ?", excludeID)
+	}
+	return query
+}
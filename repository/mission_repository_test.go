@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"testing"
+)
+
+func TestSaveWithinLimit(t *testing.T) {
+	db := openTestDB(t, &models.Alchemist{}, &models.Mission{})
+	r := NewMissionRepository(db)
+	alch := &models.Alchemist{Name: "Edward"}
+	if err := db.Create(alch).Error; err != nil {
+		t.Fatal(err)
+	}
+	closed := []string{"COMPLETED", "CANCELLED"}
+	mission := func(status string) *models.Mission {
+		return &models.Mission{Title: "m", Status: status, AssignedToID: &alch.ID}
+	}
+
+	// las cerradas no cuentan para el límite
+	if err := db.Create(mission("COMPLETED")).Error; err != nil {
+		t.Fatal(err)
+	}
+	first := mission("ASSIGNED")
+	if _, applied, err := r.SaveWithinLimit(first, "", 2, closed); err != nil || !applied {
+		t.Fatalf("primera alta: applied %v, err %v", applied, err)
+	}
+	if _, applied, err := r.SaveWithinLimit(mission("assigned"), "", 2, closed); err != nil || !applied {
+		t.Fatalf("segunda alta: applied %v, err %v", applied, err)
+	}
+	open, _, err := r.SaveWithinLimit(mission("ASSIGNED"), "", 2, closed)
+	if !errors.Is(err, ErrOpenMissionLimit) || open != 2 {
+		t.Fatalf("tercera alta: open %d, err %v; want 2 y ErrOpenMissionLimit", open, err)
+	}
+
+	// editar una misión ya contada no la cuenta dos veces
+	first.Title = "editada"
+	if _, applied, err := r.SaveWithinLimit(first, "ASSIGNED", 2, closed); err != nil || !applied {
+		t.Fatalf("edición: applied %v, err %v", applied, err)
+	}
+	// la edición desde un estado que ya cambió no se aplica
+	if _, applied, err := r.SaveWithinLimit(first, "PENDING", 2, closed); err != nil || applied {
+		t.Fatalf("edición obsoleta: applied %v, err %v", applied, err)
+	}
+
+	var count int64
+	if err := db.Model(&models.Mission{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("misiones = %d, want 3", count)
+	}
+}
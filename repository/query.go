@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Operadores admitidos en un Filter.
+const (
+	OpEq       = "="
+	OpNotEq    = "<>"
+	OpGt       = ">"
+	OpGte      = ">="
+	OpLt       = "<"
+	OpLte      = "<="
+	OpIn       = "IN"
+	OpNotIn    = "NOT IN"
+	OpContains = "CONTAINS" // LIKE sin distinguir mayúsculas
+	OpEqFold   = "EQ_FOLD"  // igualdad sin distinguir mayúsculas
+)
+
+var columnPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Filter es una condición sobre una columna. Column debe venir de una lista
+// blanca del handler; igual se valida para no aceptar SQL arbitrario.
+type Filter struct {
+	Column string
+	Op     string
+	Value  interface{}
+}
+
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// QuerySpec describe una consulta paginada. Con AfterID > 0 se pagina por
+// cursor sobre el id (según el sentido de Sort[0], que debe ser "id") y Offset
+// se ignora.
+type QuerySpec struct {
+	Filters []Filter
+	Sort    []SortField
+	Limit   int
+	Offset  int
+	AfterID uint
+}
+
+type Page[T any] struct {
+	Items   []*T
+	Total   int64
+	HasMore bool
+}
+
+func (f Filter) clause() (string, interface{}, error) {
+	if !columnPattern.MatchString(f.Column) {
+		return "", nil, fmt.Errorf("invalid filter column %q", f.Column)
+	}
+	switch f.Op {
+	case OpEq, OpNotEq, OpGt, OpGte, OpLt, OpLte, OpIn, OpNotIn:
+		return fmt.Sprintf("%s %s ?", f.Column, f.Op), f.Value, nil
+	case OpContains:
+		return fmt.Sprintf("LOWER(%s) LIKE ?", f.Column), "%" + strings.ToLower(fmt.Sprint(f.Value)) + "%", nil
+	case OpEqFold:
+		return fmt.Sprintf("LOWER(%s) = ?", f.Column), strings.ToLower(fmt.Sprint(f.Value)), nil
+	default:
+		return "", nil, fmt.Errorf("invalid filter operator %q", f.Op)
+	}
+}
+
+// findPage aplica el QuerySpec sobre el modelo T. Los preloads solo se usan en
+// la consulta de la página, no en el conteo.
+func findPage[T any](db *gorm.DB, spec QuerySpec, preloads ...string) (*Page[T], error) {
+	query := db.Model(new(T))
+	for _, f := range spec.Filters {
+		clause, value, err := f.clause()
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(clause, value)
+	}
+	// Session permite reutilizar los filtros para el conteo y la página
+	query = query.Session(&gorm.Session{})
+
+	page := &Page[T]{Items: []*T{}}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	sort := spec.Sort
+	if len(sort) == 0 {
+		sort = []SortField{{Column: "id"}}
+	}
+	if spec.AfterID > 0 {
+		if sort[0].Column != "id" {
+			return nil, fmt.Errorf("cursor pagination requires sorting by id")
+		}
+		if sort[0].Desc {
+			query = query.Where("id < ?", spec.AfterID)
+		} else {
+			query = query.Where("id > ?", spec.AfterID)
+		}
+	} else if spec.Offset > 0 {
+		query = query.Offset(spec.Offset)
+	}
+	for _, s := range sort {
+		if !columnPattern.MatchString(s.Column) {
+			return nil, fmt.Errorf("invalid sort column %q", s.Column)
+		}
+		dir := "ASC"
+		if s.Desc {
+			dir = "DESC"
+		}
+		query = query.Order(s.Column + " " + dir)
+	}
+	if spec.Limit > 0 {
+		query = query.Limit(spec.Limit + 1)
+	}
+	for _, p := range preloads {
+		query = query.Preload(p)
+	}
+	if err := query.Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+	if spec.Limit > 0 && len(page.Items) > spec.Limit {
+		page.Items = page.Items[:spec.Limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type pageItem struct {
+	ID   uint
+	Name string
+	Kind string
+}
+
+// openTestDB abre una base sqlite en un directorio temporal con las tablas de models.
+func openTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newPageDB crea 10 filas: ids 1..10, Kind "par"/"impar" y Name "item-NN".
+func newPageDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t, &pageItem{})
+	for i := 1; i <= 10; i++ {
+		kind := "impar"
+		if i%2 == 0 {
+			kind = "par"
+		}
+		item := &pageItem{Name: fmt.Sprintf("item-%02d", i), Kind: kind}
+		if err := db.Create(item).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func pageIDs(p *Page[pageItem]) []uint {
+	ids := make([]uint, 0, len(p.Items))
+	for _, it := range p.Items {
+		ids = append(ids, it.ID)
+	}
+	return ids
+}
+
+func TestFindPage(t *testing.T) {
+	db := newPageDB(t)
+	cases := []struct {
+		name        string
+		spec        QuerySpec
+		wantIDs     []uint
+		wantTotal   int64
+		wantHasMore bool
+	}{
+		{
+			name:        "primera página por offset",
+			spec:        QuerySpec{Limit: 3},
+			wantIDs:     []uint{1, 2, 3},
+			wantTotal:   10,
+			wantHasMore: true,
+		},
+		{
+			name:        "offset intermedio",
+			spec:        QuerySpec{Limit: 3, Offset: 6},
+			wantIDs:     []uint{7, 8, 9},
+			wantTotal:   10,
+			wantHasMore: true,
+		},
+		{
+			// con limit+1 se sabe que no hay más sin contar de nuevo
+			name:      "última página exacta no tiene más",
+			spec:      QuerySpec{Limit: 5, Offset: 5},
+			wantIDs:   []uint{6, 7, 8, 9, 10},
+			wantTotal: 10,
+		},
+		{
+			name:      "sin límite trae todo",
+			spec:      QuerySpec{Filters: []Filter{{Column: "kind", Op: OpEq, Value: "par"}}},
+			wantIDs:   []uint{2, 4, 6, 8, 10},
+			wantTotal: 5,
+		},
+		{
+			name:        "cursor ascendente ignora offset",
+			spec:        QuerySpec{Limit: 2, Offset: 8, AfterID: 4},
+			wantIDs:     []uint{5, 6},
+			wantTotal:   10,
+			wantHasMore: true,
+		},
+		{
+			name:        "cursor descendente",
+			spec:        QuerySpec{Sort: []SortField{{Column: "id", Desc: true}}, Limit: 3, AfterID: 5},
+			wantIDs:     []uint{4, 3, 2},
+			wantTotal:   10,
+			wantHasMore: true,
+		},
+		{
+			name:      "cursor con filtro: el total no depende del cursor",
+			spec:      QuerySpec{Filters: []Filter{{Column: "kind", Op: OpEq, Value: "impar"}}, Limit: 5, AfterID: 5},
+			wantIDs:   []uint{7, 9},
+			wantTotal: 5,
+		},
+		{
+			name:      "contains sin distinguir mayúsculas e IN",
+			spec:      QuerySpec{Filters: []Filter{{Column: "name", Op: OpContains, Value: "ITEM-0"}, {Column: "id", Op: OpIn, Value: []uint{1, 9, 10}}}},
+			wantIDs:   []uint{1, 9},
+			wantTotal: 2,
+		},
+		{
+			name:        "igualdad sin distinguir mayúsculas",
+			spec:        QuerySpec{Filters: []Filter{{Column: "kind", Op: OpEqFold, Value: "PAR"}}, Limit: 2},
+			wantIDs:     []uint{2, 4},
+			wantTotal:   5,
+			wantHasMore: true,
+		},
+		{
+			name:        "orden por varias columnas",
+			spec:        QuerySpec{Sort: []SortField{{Column: "kind"}, {Column: "id", Desc: true}}, Limit: 4},
+			wantIDs:     []uint{9, 7, 5, 3},
+			wantTotal:   10,
+			wantHasMore: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := findPage[pageItem](db, tc.spec)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got := pageIDs(page); !slices.Equal(got, tc.wantIDs) {
+				t.Errorf("ids = %v, want %v", got, tc.wantIDs)
+			}
+			if page.Total != tc.wantTotal {
+				t.Errorf("Total = %d, want %d", page.Total, tc.wantTotal)
+			}
+			if page.HasMore != tc.wantHasMore {
+				t.Errorf("HasMore = %v, want %v", page.HasMore, tc.wantHasMore)
+			}
+		})
+	}
+}
+
+func TestFindPageRejectsInvalidSpec(t *testing.T) {
+	db := newPageDB(t)
+	cases := []struct {
+		name    string
+		spec    QuerySpec
+		wantErr string
+	}{
+		{"columna de filtro con SQL", QuerySpec{Filters: []Filter{{Column: "kind; DROP TABLE page_items", Op: OpEq, Value: "x"}}}, "invalid filter column"},
+		{"columna de filtro en mayúsculas", QuerySpec{Filters: []Filter{{Column: "Kind", Op: OpEq, Value: "x"}}}, "invalid filter column"},
+		{"operador desconocido", QuerySpec{Filters: []Filter{{Column: "kind", Op: "LIKE", Value: "x"}}}, "invalid filter operator"},
+		{"columna de orden con SQL", QuerySpec{Sort: []SortField{{Column: "id DESC, (SELECT 1)"}}}, "invalid sort column"},
+		{"cursor sin ordenar por id", QuerySpec{Sort: []SortField{{Column: "name"}}, AfterID: 3}, "cursor pagination requires sorting by id"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := findPage[pageItem](db, tc.spec)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
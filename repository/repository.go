@@ -1,10 +1,21 @@
 package repository
 
+import "backend-avanzada/models"
+
 type Entity interface{}
 
 type Repository[T Entity] interface {
 	FindAll() ([]*T, error)
+	FindPage(spec QuerySpec) (*Page[T], error)
 	FindById(id int) (*T, error)
 	Save(*T) (*T, error)
 	Delete(*T) error
 }
+
+var (
+	_ Repository[models.Alchemist]     = (*AlchemistRepository)(nil)
+	_ Repository[models.Material]      = (*MaterialRepository)(nil)
+	_ Repository[models.Mission]       = (*MissionRepository)(nil)
+	_ Repository[models.Transmutation] = (*TransmutationRepository)(nil)
+	_ Repository[models.Webhook]       = (*WebhookRepository)(nil)
+)
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TokenRepository struct{ db *gorm.DB }
+
+func NewTokenRepository(db *gorm.DB) *TokenRepository { return &TokenRepository{db} }
+
+func (r *TokenRepository) SaveRefresh(t *models.RefreshToken) (*models.RefreshToken, error) {
+	return t, r.db.Save(t).Error
+}
+
+func (r *TokenRepository) FindRefreshByHash(hash string) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	err := r.db.Where("token_hash = ?", hash).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &t, err
+}
+
+// ConsumeRefresh marca el token como rotado solo si seguía vigente; devuelve
+// false si otra petición lo consumió antes (reuso).
+func (r *TokenRepository) ConsumeRefresh(id uint, at time.Time) (bool, error) {
+	res := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"revoked_at": at, "rotated_at": at})
+	return res.RowsAffected == 1, res.Error
+}
+
+// RevokeFamily revoca todos los refresh de la familia y los access tokens
+// emitidos con ellos que aún no expiraron.
+func (r *TokenRepository) RevokeFamily(familyID string, at time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var tokens []*models.RefreshToken
+		if err := tx.Where("family_id = ?", familyID).Find(&tokens).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", at).Error; err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			if t.AccessJTI == "" || !t.AccessExpiresAt.After(at) {
+				continue
+			}
+			if err := revokeAccess(tx, t.AccessJTI, t.AccessExpiresAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RevokeUser cierra todas las sesiones de un usuario.
+func (r *TokenRepository) RevokeUser(userID uint, at time.Time) error {
+	var families []string
+	if err := r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Distinct().Pluck("family_id", &families).Error; err != nil {
+		return err
+	}
+	for _, f := range families {
+		if err := r.RevokeFamily(f, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *TokenRepository) RevokeAccess(jti string, expiresAt time.Time) error {
+	return revokeAccess(r.db, jti, expiresAt)
+}
+
+func revokeAccess(db *gorm.DB, jti string, expiresAt time.Time) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (r *TokenRepository) IsRevoked(jti string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// PurgeExpired borra revocaciones, refresh y tokens de recuperación que ya no pueden usarse.
+func (r *TokenRepository) PurgeExpired(now time.Time) error {
+	if err := r.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.Unscoped().Where("expires_at < ?", now).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		return err
+	}
+	return r.db.Unscoped().Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error
+}
+
+func (r *TokenRepository) SavePasswordReset(t *models.PasswordResetToken) (*models.PasswordResetToken, error) {
+	return t, r.db.Save(t).Error
+}
+
+func (r *TokenRepository) FindPasswordResetByHash(hash string) (*models.PasswordResetToken, error) {
+	var t models.PasswordResetToken
+	err := r.db.Where("token_hash = ?", hash).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &t, err
+}
+
+// ConsumePasswordReset marca como usado el token y cualquier otro pendiente
+// del mismo usuario; devuelve false si ya se había usado.
+func (r *TokenRepository) ConsumePasswordReset(t *models.PasswordResetToken, at time.Time) (bool, error) {
+	res := r.db.Model(&models.PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", t.ID).
+		Update("used_at", at)
+	if res.Error != nil || res.RowsAffected != 1 {
+		return false, res.Error
+	}
+	err := r.db.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", t.UserID).
+		Update("used_at", at).Error
+	return true, err
+}
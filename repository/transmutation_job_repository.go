@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type TransmutationJobRepository struct{ db *gorm.DB }
+
+func NewTransmutationJobRepository(db *gorm.DB) *TransmutationJobRepository {
+	return &TransmutationJobRepository{db}
+}
+
+func (r *TransmutationJobRepository) FindAll() ([]*models.TransmutationJob, error) {
+	var list []*models.TransmutationJob
+	return list, r.db.Order("due_at ASC").Find(&list).Error
+}
+
+func (r *TransmutationJobRepository) FindByTransmutationID(id uint) (*models.TransmutationJob, error) {
+	var j models.TransmutationJob
+	err := r.db.Where("transmutation_id = ?", id).First(&j).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &j, err
+}
+
+func (r *TransmutationJobRepository) Save(j *models.TransmutationJob) (*models.TransmutationJob, error) {
+	return j, r.db.Save(j).Error
+}
+
+// DeleteByTransmutationID borra físicamente el job para que la transmutación
+// pueda volver a programarse sin chocar con el índice único.
+func (r *TransmutationJobRepository) DeleteByTransmutationID(id uint) error {
+	return r.db.Unscoped().Where("transmutation_id = ?", id).Delete(&models.TransmutationJob{}).Error
+}
@@ -3,8 +3,16 @@ package repository
 import (
 	"backend-avanzada/models"
 	"errors"
+	"fmt"
+	"math"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInsufficientStock = errors.New("insufficient material stock")
+	ErrActiveLimit       = errors.New("active transmutation limit reached")
 )
 
 type TransmutationRepository struct {
@@ -17,16 +25,20 @@ func NewTransmutationRepository(db *gorm.DB) *TransmutationRepository {
 
 func (r *TransmutationRepository) FindAll() ([]*models.Transmutation, error) {
 	var items []*models.Transmutation
-	err := r.db.Preload("Alchemist").Order("id DESC").Find(&items).Error
+	err := r.db.Preload("Alchemist").Preload("Materials.Material").Order("id DESC").Find(&items).Error
 	if err != nil {
 		return nil, err
 	}
 	return items, nil
 }
 
+func (r *TransmutationRepository) FindPage(spec QuerySpec) (*Page[models.Transmutation], error) {
+	return findPage[models.Transmutation](r.db, spec, "Alchemist", "Materials.Material")
+}
+
 func (r *TransmutationRepository) FindById(id int) (*models.Transmutation, error) {
 	var t models.Transmutation
-	err := r.db.Preload("Alchemist").Where("id = ?", id).First(&t).Error
+	err := r.db.Preload("Alchemist").Preload("Materials.Material").Where("id = ?", id).First(&t).Error
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, err
 	}
@@ -35,6 +47,35 @@ func (r *TransmutationRepository) FindById(id int) (*models.Transmutation, error
 	}
 	return &t, nil
 }
+func (r *TransmutationRepository) FindByStatus(status string) ([]*models.Transmutation, error) {
+	var items []*models.Transmutation
+	err := r.db.Preload("Alchemist").Where("status = ?", status).Order("id ASC").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// CountByStatus devuelve cuántas transmutaciones hay en cada estado.
+func (r *TransmutationRepository) CountByStatus() (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := r.db.Model(&models.Transmutation{}).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
 func (r *TransmutationRepository) HasActiveForAlchemist(alchemistID uint, statuses ...string) (bool, error) {
 	if len(statuses) == 0 {
 		statuses = []string{"IN_PROGRESS"}
@@ -63,6 +104,131 @@ func (r *TransmutationRepository) UpdateStatus(id uint, status string) error {
 		Update("status", status).Error
 }
 
+// CreateWithinLimit guarda la transmutación solo si su alquimista tiene menos
+// de limit en los estados indicados. El conteo y el insert van en la misma
+// transacción con la fila del alquimista bloqueada, así dos pedidos en
+// paralelo no pasan juntos el límite (sqlite ignora el bloqueo; ahí lo
+// serializa el servidor). Devuelve las activas que encontró.
+func (r *TransmutationRepository) CreateWithinLimit(t *models.Transmutation, limit int64, statuses ...string) (int64, error) {
+	var active int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var alch models.Alchemist
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&alch, t.AlchemistID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Transmutation{}).
+			Where("alchemist_id = ? AND status IN ?", t.AlchemistID, statuses).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active >= limit {
+			return ErrActiveLimit
+		}
+		return tx.Omit("Alchemist").Create(t).Error
+	})
+	return active, err
+}
+
+// UpdateStatusFrom cambia el estado solo si sigue siendo from. Devuelve false
+// si otro request o tarea lo cambió antes.
+func (r *TransmutationRepository) UpdateStatusFrom(id uint, from, to string) (bool, error) {
+	res := r.db.Model(&models.Transmutation{}).
+		Where("id = ? AND status = ?", id, from).
+		Update("status", to)
+	return res.RowsAffected == 1, res.Error
+}
+
+// ApproveReserving pasa la transmutación de from a to y descuenta del stock sus
+// materiales en la misma transacción. Devuelve false, sin tocar el stock, si el
+// estado ya no era from; falla con ErrInsufficientStock (sin cambiar nada) si
+// algún material no alcanza.
+func (r *TransmutationRepository) ApproveReserving(id uint, from, to string) (bool, error) {
+	approved := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Transmutation{}).
+			Where("id = ? AND status = ?", id, from).
+			Update("status", to)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		if err := reserveMaterials(tx, id); err != nil {
+			return err
+		}
+		approved = true
+		return nil
+	})
+	return approved && err == nil, err
+}
+
+// reserveMaterials marca la reserva y descuenta cada material; no hace nada si
+// la transmutación ya tenía el stock reservado.
+func reserveMaterials(tx *gorm.DB, id uint) error {
+	res := tx.Model(&models.Transmutation{}).
+		Where("id = ? AND stock_reserved = ?", id, false).
+		Update("stock_reserved", true)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	var items []models.TransmutationMaterial
+	if err := tx.Where("transmutation_id = ?", id).Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		upd := tx.Model(&models.Material{}).
+			Where("id = ? AND stock >= ?", item.MaterialID, item.Quantity).
+			Update("stock", gorm.Expr("stock - ?", item.Quantity))
+		if upd.Error != nil {
+			return upd.Error
+		}
+		if upd.RowsAffected == 0 {
+			return fmt.Errorf("%w: material %d", ErrInsufficientStock, item.MaterialID)
+		}
+	}
+	return nil
+}
+
+// ReleaseMaterials devuelve al stock la fracción ratio (0..1) de los materiales
+// reservados por la transmutación; el resto se da por consumido. No hace nada si
+// la reserva ya fue liberada.
+func (r *TransmutationRepository) ReleaseMaterials(id uint, ratio float64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Transmutation{}).
+			Where("id = ? AND stock_reserved = ?", id, true).
+			Update("stock_reserved", false)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		var items []models.TransmutationMaterial
+		if err := tx.Where("transmutation_id = ?", id).Find(&items).Error; err != nil {
+			return err
+		}
+		for _, item := range items {
+			returned := math.Round(item.Quantity*ratio*100) / 100
+			if returned <= 0 {
+				continue
+			}
+			if err := tx.Model(&models.Material{}).
+				Where("id = ?", item.MaterialID).
+				Update("stock", gorm.Expr("stock + ?", returned)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MarkFailed deja la transmutación en FAILED con el motivo indicado, solo si
+// sigue en el estado from. Devuelve false si ya había cambiado.
+func (r *TransmutationRepository) MarkFailed(id uint, from, status, reason string) (bool, error) {
+	res := r.db.Model(&models.Transmutation{}).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{"status": status, "failure_reason": reason})
+	return res.RowsAffected == 1, res.Error
+}
+
 func (r *TransmutationRepository) Delete(data *models.Transmutation) error {
 	return r.db.Delete(data).Error
 }
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"testing"
+)
+
+func newTransmutationTestDB(t *testing.T, stock, quantity float64) (*TransmutationRepository, *models.Transmutation, *models.Material) {
+	t.Helper()
+	db := openTestDB(t, &models.Alchemist{}, &models.Material{}, &models.Transmutation{}, &models.TransmutationMaterial{})
+	mat := &models.Material{Name: "Hierro", Unit: "kg", Cost: 2, Stock: stock}
+	if err := db.Create(mat).Error; err != nil {
+		t.Fatal(err)
+	}
+	tr := &models.Transmutation{Description: "prueba", Status: "PENDING_APPROVAL", AlchemistID: 1}
+	if err := db.Create(tr).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.TransmutationMaterial{TransmutationID: tr.ID, MaterialID: mat.ID, Quantity: quantity}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewTransmutationRepository(db), tr, mat
+}
+
+func transmutationState(t *testing.T, r *TransmutationRepository, tr *models.Transmutation, mat *models.Material) (string, bool, float64) {
+	t.Helper()
+	var gotT models.Transmutation
+	var gotM models.Material
+	if err := r.db.First(&gotT, tr.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := r.db.First(&gotM, mat.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return gotT.Status, gotT.StockReserved, gotM.Stock
+}
+
+func TestApproveReservingOnlyOnce(t *testing.T) {
+	repo, tr, mat := newTransmutationTestDB(t, 100, 10)
+
+	approved, err := repo.ApproveReserving(tr.ID, "PENDING_APPROVAL", "IN_PROGRESS")
+	if err != nil || !approved {
+		t.Fatalf("primera aprobación = %v, %v; want true, nil", approved, err)
+	}
+	// la segunda pierde la carrera: no descuenta ni devuelve stock
+	approved, err = repo.ApproveReserving(tr.ID, "PENDING_APPROVAL", "IN_PROGRESS")
+	if err != nil || approved {
+		t.Fatalf("segunda aprobación = %v, %v; want false, nil", approved, err)
+	}
+	status, reserved, stock := transmutationState(t, repo, tr, mat)
+	if status != "IN_PROGRESS" || !reserved || stock != 90 {
+		t.Errorf("estado = %s, reservado %v, stock %v; want IN_PROGRESS, true, 90", status, reserved, stock)
+	}
+}
+
+func TestApproveReservingInsufficientStockRollsBack(t *testing.T) {
+	repo, tr, mat := newTransmutationTestDB(t, 5, 10)
+
+	approved, err := repo.ApproveReserving(tr.ID, "PENDING_APPROVAL", "IN_PROGRESS")
+	if !errors.Is(err, ErrInsufficientStock) || approved {
+		t.Fatalf("aprobación = %v, %v; want false, ErrInsufficientStock", approved, err)
+	}
+	status, reserved, stock := transmutationState(t, repo, tr, mat)
+	if status != "PENDING_APPROVAL" || reserved || stock != 5 {
+		t.Errorf("estado = %s, reservado %v, stock %v; want PENDING_APPROVAL, false, 5", status, reserved, stock)
+	}
+}
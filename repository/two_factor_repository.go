@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TwoFactorRepository struct{ db *gorm.DB }
+
+func NewTwoFactorRepository(db *gorm.DB) *TwoFactorRepository { return &TwoFactorRepository{db} }
+
+func (r *TwoFactorRepository) SaveChallenge(c *models.LoginChallenge) (*models.LoginChallenge, error) {
+	return c, r.db.Save(c).Error
+}
+
+func (r *TwoFactorRepository) FindChallengeByHash(hash string) (*models.LoginChallenge, error) {
+	var c models.LoginChallenge
+	err := r.db.Where("token_hash = ?", hash).First(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &c, err
+}
+
+// AddChallengeAttempt suma un intento fallido y devuelve el total.
+func (r *TwoFactorRepository) AddChallengeAttempt(c *models.LoginChallenge) (int, error) {
+	err := r.db.Model(c).UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+	if err != nil {
+		return 0, err
+	}
+	return c.Attempts + 1, nil
+}
+
+// ConsumeChallenge marca el desafío como usado; devuelve false si ya lo estaba.
+func (r *TwoFactorRepository) ConsumeChallenge(id uint, at time.Time) (bool, error) {
+	res := r.db.Model(&models.LoginChallenge{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", at)
+	return res.RowsAffected == 1, res.Error
+}
+
+// ReplaceRecoveryCodes borra los códigos anteriores del usuario y guarda los nuevos.
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]models.RecoveryCode, 0, len(hashes))
+		for _, h := range hashes {
+			codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: h})
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode consume el código si pertenece al usuario y no se usó.
+func (r *TwoFactorRepository) UseRecoveryCode(userID uint, hash string, at time.Time) (bool, error) {
+	res := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", at)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *TwoFactorRepository) CountUnusedRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *TwoFactorRepository) DeleteRecoveryCodes(userID uint) error {
+	return r.db.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+func (r *TwoFactorRepository) PurgeExpired(now time.Time) error {
+	return r.db.Unscoped().Where("expires_at < ?", now).Delete(&models.LoginChallenge{}).Error
+}
@@ -3,10 +3,17 @@ package repository
 import (
 	"backend-avanzada/models"
 	"errors"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+var (
+	ErrEmailRegistered = errors.New("email already registered")
+	ErrAlchemistLinked = errors.New("alchemist is already linked to another user")
+)
+
 type UserRepository struct{ db *gorm.DB }
 
 func NewUserRepository(db *gorm.DB) *UserRepository { return &UserRepository{db} }
@@ -20,6 +27,80 @@ func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
 	return &u, err
 }
 
+func (r *UserRepository) FindById(id uint) (*models.User, error) {
+	var u models.User
+	err := r.db.Where("id = ?", id).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &u, err
+}
+
+// FindByAlchemistID devuelve el usuario vinculado al alquimista, si existe.
+func (r *UserRepository) FindByAlchemistID(alchemistID uint) (*models.User, error) {
+	var u models.User
+	err := r.db.Where("alchemist_id = ?", alchemistID).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &u, err
+}
+
+func (r *UserRepository) FindPage(spec QuerySpec) (*Page[models.User], error) {
+	return findPage[models.User](r.db, spec)
+}
+
+// CountByRole cuenta los usuarios habilitados con ese rol.
+func (r *UserRepository) CountByRole(role string) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).Where("role = ? AND disabled = ?", role, false).Count(&count).Error
+	return count, err
+}
+
+// Save traduce las violaciones de los índices únicos de email y alchemist_id a
+// ErrEmailRegistered y ErrAlchemistLinked: la validación previa no alcanza si
+// dos altas o vinculaciones llegan en paralelo.
 func (r *UserRepository) Save(u *models.User) (*models.User, error) {
-	return u, r.db.Save(u).Error
+	return u, uniqueUserError(r.db.Save(u).Error)
+}
+
+// UpdateFields actualiza solo las columnas indicadas (y updated_at), para que
+// cada camino que cambia un usuario no pise lo que otro cambió en paralelo.
+func (r *UserRepository) UpdateFields(id uint, fields map[string]interface{}) error {
+	return uniqueUserError(r.db.Model(&models.User{}).Where("id = ?", id).Updates(fields).Error)
+}
+
+// uniqueUserError reconoce el mensaje de sqlite ("UNIQUE constraint failed:
+// users.alchemist_id") y el de postgres (SQLSTATE 23505 sobre
+// idx_users_alchemist_id); los dos nombran la columna.
+func uniqueUserError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "UNIQUE constraint failed") && !strings.Contains(msg, "SQLSTATE 23505") {
+		return err
+	}
+	switch {
+	case strings.Contains(msg, "alchemist_id"):
+		return ErrAlchemistLinked
+	case strings.Contains(msg, "email"):
+		return ErrEmailRegistered
+	}
+	return err
+}
+
+// SetLockedUntil toca solo locked_until, para no pisar cambios concurrentes
+// del resto de la fila (rol, estado, contraseña). nil levanta el bloqueo.
+func (r *UserRepository) SetLockedUntil(id uint, until *time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).UpdateColumn("locked_until", until).Error
+}
+
+// AdvanceTOTPStep registra el último paso TOTP aceptado. Devuelve false si ese
+// paso (o uno posterior) ya se usó, para que un código no sirva dos veces.
+func (r *UserRepository) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	res := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	return res.RowsAffected == 1, res.Error
 }
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"testing"
+)
+
+func TestUpdateFieldsKeepsOtherColumns(t *testing.T) {
+	r := NewUserRepository(openTestDB(t, &models.User{}))
+	alchemistID := uint(7)
+	u := &models.User{Email: "a@x.com", PasswordHash: "hash-1", Role: "ALCHEMIST", AlchemistID: &alchemistID}
+	if _, err := r.Save(u); err != nil {
+		t.Fatal(err)
+	}
+	other := &models.User{Email: "b@x.com", Role: "ALCHEMIST"}
+	if _, err := r.Save(other); err != nil {
+		t.Fatal(err)
+	}
+
+	// copia vieja en memoria: otro request cambia el rol mientras tanto
+	if err := r.UpdateFields(u.ID, map[string]interface{}{"role": "SUPERVISOR"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpdateFields(u.ID, map[string]interface{}{"password_hash": "hash-2", "totp_enabled": false}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.FindById(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Role != "SUPERVISOR" || got.PasswordHash != "hash-2" || got.AlchemistID == nil || *got.AlchemistID != 7 {
+		t.Errorf("usuario = role %q, hash %q, alchemist %v", got.Role, got.PasswordHash, got.AlchemistID)
+	}
+
+	err = r.UpdateFields(other.ID, map[string]interface{}{"alchemist_id": alchemistID})
+	if !errors.Is(err, ErrAlchemistLinked) {
+		t.Errorf("err = %v, want ErrAlchemistLinked", err)
+	}
+}
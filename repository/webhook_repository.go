@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WebhookRepository struct{ db *gorm.DB }
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository { return &WebhookRepository{db} }
+
+func (r *WebhookRepository) FindAll() ([]*models.Webhook, error) {
+	var list []*models.Webhook
+	return list, r.db.Find(&list).Error
+}
+
+func (r *WebhookRepository) FindPage(spec QuerySpec) (*Page[models.Webhook], error) {
+	return findPage[models.Webhook](r.db, spec)
+}
+
+func (r *WebhookRepository) FindActive() ([]*models.Webhook, error) {
+	var list []*models.Webhook
+	return list, r.db.Where("active = ?", true).Find(&list).Error
+}
+
+func (r *WebhookRepository) FindById(id int) (*models.Webhook, error) {
+	var w models.Webhook
+	err := r.db.Where("id = ?", id).First(&w).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &w, err
+}
+
+func (r *WebhookRepository) Save(w *models.Webhook) (*models.Webhook, error) {
+	return w, r.db.Save(w).Error
+}
+
+func (r *WebhookRepository) Delete(w *models.Webhook) error {
+	return r.db.Delete(w).Error
+}
+
+type WebhookDeliveryRepository struct{ db *gorm.DB }
+
+func NewWebhookDeliveryRepository(db *gorm.DB) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{db}
+}
+
+func (r *WebhookDeliveryRepository) FindPage(spec QuerySpec) (*Page[models.WebhookDelivery], error) {
+	return findPage[models.WebhookDelivery](r.db, spec)
+}
+
+func (r *WebhookDeliveryRepository) Save(d *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	return d, r.db.Save(d).Error
+}
+
+// FindPendingRetries devuelve los intentos fallidos que todavía esperan reintento.
+func (r *WebhookDeliveryRepository) FindPendingRetries() ([]*models.WebhookDelivery, error) {
+	var list []*models.WebhookDelivery
+	return list, r.db.Where("next_attempt_at IS NOT NULL").Order("next_attempt_at").Find(&list).Error
+}
+
+// ClearNextAttempt marca que el reintento de un intento ya se tomó.
+func (r *WebhookDeliveryRepository) ClearNextAttempt(id uint) error {
+	return r.db.Model(&models.WebhookDelivery{}).Where("id = ?", id).
+		UpdateColumn("next_attempt_at", (*time.Time)(nil)).Error
+}
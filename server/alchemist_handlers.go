@@ -3,36 +3,57 @@ package server
 import (
 	"backend-avanzada/api"
 	"backend-avanzada/models"
+	"backend-avanzada/repository"
 	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
 )
 
+var alchemistSortFields = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"age":        "age",
+	"specialty":  "specialty",
+	"rank":       "rank",
+	"created_at": "created_at",
+}
+
 func (s *Server) HandleAlchemists(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		start := time.Now()
-		list, err := s.AlchemistRepository.FindAll()
+		q := r.URL.Query()
+		spec, pageReq, err := parseListQuery(q, alchemistSortFields)
+		if err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		spec.Filters = append(spec.Filters, filterIfPresent(q, "rank", "rank")...)
+		spec.Filters = append(spec.Filters, filterIfPresent(q, "specialty", "specialty")...)
+		if name := strings.TrimSpace(q.Get("q")); name != "" {
+			spec.Filters = append(spec.Filters, repository.Filter{Column: "name", Op: repository.OpContains, Value: name})
+		}
+		page, err := s.AlchemistRepository.FindPage(spec)
 		if err != nil {
 			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
 
-		resp := make([]*api.AlchemistResponseDto, 0, len(list))
-		for _, a := range list {
+		resp := make([]*api.AlchemistResponseDto, 0, len(page.Items))
+		var lastID uint
+		for _, a := range page.Items {
 			resp = append(resp, a.ToResponseDto())
+			lastID = a.ID
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		writePageHeaders(w, pageReq, page.Total, page.HasMore, lastID)
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
-		s.logger.Info(http.StatusOK, r.URL.Path, start)
 		return
 
 	case http.MethodPost:
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"backend-avanzada/logger"
+	"backend-avanzada/models"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	systemActorEmail = "system"
+	systemActorRole  = "SYSTEM"
+)
+
+// auditActor identifica quién originó un registro de auditoría.
+type auditActor struct {
+	UserID    *uint
+	Email     string
+	Role      string
+	SourceIP  string
+	RequestID string
+}
+
+// systemActor marca los registros generados por tareas internas
+// (verificaciones diarias, cierre automático de transmutaciones).
+var systemActor = auditActor{Email: systemActorEmail, Role: systemActorRole}
+
+// systemActorFor es systemActor con el request_id que originó la tarea.
+func systemActorFor(ctx context.Context) auditActor {
+	actor := systemActor
+	actor.RequestID = logger.RequestID(ctx)
+	return actor
+}
+
+// actorFromRequest toma el usuario de los claims que dejó AuthMiddleware.
+func actorFromRequest(r *http.Request) auditActor {
+	ctx := r.Context()
+	actor := auditActor{
+		SourceIP:  clientIP(r),
+		RequestID: logger.RequestID(ctx),
+	}
+	if uid, ok := ctx.Value(ctxUserID).(uint); ok {
+		actor.UserID = &uid
+	}
+	actor.Email, _ = ctx.Value(ctxEmail).(string)
+	actor.Role, _ = ctx.Value(ctxRole).(string)
+	return actor
+}
+
+func (a auditActor) apply(audit *models.Audit) *models.Audit {
+	audit.ActorUserID = a.UserID
+	audit.ActorEmail = a.Email
+	audit.ActorRole = a.Role
+	audit.SourceIP = a.SourceIP
+	audit.RequestID = a.RequestID
+	return audit
+}
+
+func (s *Server) saveAudit(actor auditActor, audit *models.Audit) error {
+	_, err := s.AuditRepository.Save(actor.apply(audit))
+	return err
+}
+
+// ClientIPMiddleware resuelve una vez la IP del cliente (auditorías y límites
+// de login) según trusted_proxies.
+func (s *Server) ClientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, parseTrustedProxies(s.Config().TrustedProxies))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxClientIP, ip)))
+	})
+}
+
+// clientIP devuelve la IP que dejó ClientIPMiddleware o, sin ella, la de la conexión.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ctxClientIP).(string); ok && ip != "" {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+// resolveClientIP solo mira X-Forwarded-For / X-Real-IP si la conexión viene
+// de un proxy de confianza; de otro modo el cliente podría elegir su IP. En
+// X-Forwarded-For se recorre de derecha a izquierda y se toma el primer salto
+// que no sea un proxy de confianza.
+func resolveClientIP(r *http.Request, trusted []*net.IPNet) string {
+	ip := remoteHost(r)
+	if !ipTrusted(ip, trusted) {
+		return ip
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !ipTrusted(hop, trusted) {
+				break
+			}
+		}
+		return ip
+	}
+	if xr := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xr) != nil {
+		return xr
+	}
+	return ip
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseTrustedProxies acepta IPs sueltas o CIDR; config.Load ya descarta las inválidas.
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, n)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return nets
+}
+
+func ipTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
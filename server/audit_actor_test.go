@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.5"})
+	cases := []struct {
+		name    string
+		remote  string
+		fwd     string
+		realIP  string
+		trusted bool
+		want    string
+	}{
+		{name: "sin proxies ignora headers", remote: "203.0.113.7:5000", fwd: "1.2.3.4", realIP: "5.6.7.8", want: "203.0.113.7"},
+		{name: "conexión directa no confiable", remote: "203.0.113.7:5000", fwd: "1.2.3.4", trusted: true, want: "203.0.113.7"},
+		{name: "proxy confiable", remote: "10.1.2.3:443", fwd: "1.2.3.4", trusted: true, want: "1.2.3.4"},
+		{name: "cadena de proxies", remote: "10.1.2.3:443", fwd: "9.9.9.9, 1.2.3.4, 192.168.1.5", trusted: true, want: "1.2.3.4"},
+		{name: "primer salto falsificado", remote: "10.1.2.3:443", fwd: "6.6.6.6, 1.2.3.4", trusted: true, want: "1.2.3.4"},
+		{name: "salto inválido", remote: "10.1.2.3:443", fwd: "basura, 10.0.0.9", trusted: true, want: "10.0.0.9"},
+		{name: "x-real-ip de proxy confiable", remote: "192.168.1.5:80", realIP: "1.2.3.4", trusted: true, want: "1.2.3.4"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remote
+			if tc.fwd != "" {
+				r.Header.Set("X-Forwarded-For", tc.fwd)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+			proxies := trusted
+			if !tc.trusted {
+				proxies = nil
+			}
+			if got := resolveClientIP(r, proxies); got != tc.want {
+				t.Errorf("resolveClientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
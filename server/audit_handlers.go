@@ -1,8 +1,15 @@
 package server
 
 import (
+	"backend-avanzada/api"
+	"backend-avanzada/repository"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (s *Server) HandleAudits(w http.ResponseWriter, r *http.Request) {
@@ -10,14 +17,115 @@ func (s *Server) HandleAudits(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	list, err := s.AuditRepository.FindAll()
+	spec, err := parseAuditQuery(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
 		return
 	}
-	resp := []interface{}{}
-	for _, a := range list {
+	page, err := s.AuditRepository.FindPage(spec)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	resp := make([]*api.AuditResponseDto, 0, len(page.Items))
+	for _, a := range page.Items {
 		resp = append(resp, a.ToResponseDto())
 	}
-	json.NewEncoder(w).Encode(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	if page.HasMore && len(page.Items) > 0 {
+		w.Header().Set("X-Next-Cursor", encodeCursor(page.Items[len(page.Items)-1].ID))
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+}
+
+// parseAuditQuery interpreta los query params de GET /audits:
+// action, entity, entity_id, actor (id o email), from, to, q, sort, cursor y limit.
+// La paginación es solo por cursor, así que sort acepta únicamente id o -id
+// (los ids siguen el orden de creación).
+func parseAuditQuery(q url.Values) (repository.QuerySpec, error) {
+	spec := repository.QuerySpec{
+		Sort:  []repository.SortField{{Column: "id", Desc: true}},
+		Limit: defaultPageSize,
+	}
+	add := func(column, op string, value interface{}) {
+		spec.Filters = append(spec.Filters, repository.Filter{Column: column, Op: op, Value: value})
+	}
+	if v := strings.TrimSpace(q.Get("action")); v != "" {
+		add("action", repository.OpEqFold, v)
+	}
+	if v := strings.TrimSpace(q.Get("entity")); v != "" {
+		add("entity", repository.OpEqFold, v)
+	}
+	if v := strings.TrimSpace(q.Get("entity_id")); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return spec, fmt.Errorf("invalid entity_id %q", v)
+		}
+		add("entity_id", repository.OpEq, uint(id))
+	}
+	if v := strings.TrimSpace(q.Get("actor")); v != "" {
+		if id, err := strconv.ParseUint(v, 10, 64); err == nil {
+			add("actor_user_id", repository.OpEq, uint(id))
+		} else {
+			add("actor_email", repository.OpEqFold, v)
+		}
+	}
+	if v := strings.TrimSpace(q.Get("from")); v != "" {
+		from, _, err := parseAuditDate(v)
+		if err != nil {
+			return spec, fmt.Errorf("invalid from %q", v)
+		}
+		add("created_at", repository.OpGte, from)
+	}
+	if v := strings.TrimSpace(q.Get("to")); v != "" {
+		to, dateOnly, err := parseAuditDate(v)
+		if err != nil {
+			return spec, fmt.Errorf("invalid to %q", v)
+		}
+		// una fecha sin hora incluye el día completo
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		add("created_at", repository.OpLt, to)
+	}
+	if v := strings.TrimSpace(q.Get("q")); v != "" {
+		add("description", repository.OpContains, v)
+	}
+	switch v := strings.TrimSpace(q.Get("sort")); v {
+	case "", "-id":
+	case "id":
+		spec.Sort[0].Desc = false
+	default:
+		return spec, fmt.Errorf("invalid sort %q: audits sort by id or -id", v)
+	}
+	if v := strings.TrimSpace(q.Get("cursor")); v != "" {
+		id, err := decodeCursor(v)
+		if err != nil {
+			return spec, fmt.Errorf("invalid cursor")
+		}
+		spec.AfterID = id
+	}
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return spec, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+		spec.Limit = limit
+	}
+	return spec, nil
+}
+
+func parseAuditDate(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	return t, err == nil, err
 }
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"backend-avanzada/repository"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAuditQuery(t *testing.T) {
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	newest := []repository.SortField{{Column: "id", Desc: true}}
+	cases := []struct {
+		name    string
+		query   string
+		want    repository.QuerySpec
+		wantErr string
+	}{
+		{
+			name: "por defecto los más nuevos primero",
+			want: repository.QuerySpec{Sort: newest, Limit: defaultPageSize},
+		},
+		{
+			name:  "filtros",
+			query: "action=login_failed&entity=User&entity_id=7&actor=a@x.com&q=bloqueada",
+			want: repository.QuerySpec{
+				Filters: []repository.Filter{
+					{Column: "action", Op: repository.OpEqFold, Value: "login_failed"},
+					{Column: "entity", Op: repository.OpEqFold, Value: "User"},
+					{Column: "entity_id", Op: repository.OpEq, Value: uint(7)},
+					{Column: "actor_email", Op: repository.OpEqFold, Value: "a@x.com"},
+					{Column: "description", Op: repository.OpContains, Value: "bloqueada"},
+				},
+				Sort:  newest,
+				Limit: defaultPageSize,
+			},
+		},
+		{
+			name:  "actor numérico y rango de fechas con día completo",
+			query: "actor=3&from=2026-03-01&to=2026-03-01",
+			want: repository.QuerySpec{
+				Filters: []repository.Filter{
+					{Column: "actor_user_id", Op: repository.OpEq, Value: uint(3)},
+					{Column: "created_at", Op: repository.OpGte, Value: day},
+					{Column: "created_at", Op: repository.OpLt, Value: day.AddDate(0, 0, 1)},
+				},
+				Sort:  newest,
+				Limit: defaultPageSize,
+			},
+		},
+		{
+			name:  "ascendente con cursor y limit",
+			query: "sort=id&cursor=" + encodeCursor(9) + "&limit=5000",
+			want:  repository.QuerySpec{Sort: []repository.SortField{{Column: "id"}}, Limit: maxPageSize, AfterID: 9},
+		},
+		{name: "created_at no se acepta como orden", query: "sort=created_at", wantErr: "invalid sort"},
+		{name: "-created_at tampoco", query: "sort=-created_at", wantErr: "invalid sort"},
+		{name: "entity_id no numérico", query: "entity_id=x", wantErr: "invalid entity_id"},
+		{name: "fecha inválida", query: "from=ayer", wantErr: "invalid from"},
+		{name: "limit cero", query: "limit=0", wantErr: "invalid limit"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec, err := parseAuditQuery(q)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !reflect.DeepEqual(spec, tc.want) {
+				t.Errorf("spec = %+v\nwant %+v", spec, tc.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"os"
 	"strconv"
@@ -14,6 +18,8 @@ type jwtClaims struct {
 	UserID uint   `json:"uid"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+	// AlchemistID vincula a un ALCHEMIST con su ficha de alquimista
+	AlchemistID *uint `json:"aid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -34,22 +40,77 @@ func jwtSecret() []byte {
 	return []byte(sec)
 }
 
-func generateToken(userID uint, email, role string, ttlHours int) (string, error) {
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 24 * time.Hour
+)
+
+// accessTokenTTL es la vida del JWT de acceso (JWT_ACCESS_TTL_MINUTES).
+func accessTokenTTL() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("JWT_ACCESS_TTL_MINUTES")); err == nil && v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return defaultAccessTokenTTL
+}
+
+// refreshTokenTTL es la duración de la sesión (JWT_EXPIRES_HOURS): pasado ese
+// tiempo el refresh token deja de servir y hay que volver a iniciar sesión.
+func refreshTokenTTL() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("JWT_EXPIRES_HOURS")); err == nil && v > 0 {
+		return time.Duration(v) * time.Hour
+	}
+	return defaultRefreshTokenTTL
+}
+
+type issuedToken struct {
+	Token     string
+	JTI       string
+	ExpiresAt time.Time
+}
+
+func generateToken(userID uint, email, role string, alchemistID *uint, ttl time.Duration) (*issuedToken, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
 	now := time.Now()
+	expires := now.Add(ttl)
 	claims := jwtClaims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:      userID,
+		Email:       email,
+		Role:        role,
+		AlchemistID: alchemistID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			Issuer:    "ametris-api",
 			Subject:   strconv.Itoa(int(userID)),
-			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(ttlHours) * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(expires),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return tok.SignedString(jwtSecret())
+	signed, err := tok.SignedString(jwtSecret())
+	if err != nil {
+		return nil, err
+	}
+	return &issuedToken{Token: signed, JTI: jti, ExpiresAt: expires}, nil
+}
+
+// randomToken genera n bytes aleatorios codificados en base64 URL.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken: los refresh tokens son aleatorios de 256 bits, así que
+// basta un SHA-256 (y permite buscarlos por hash).
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func parseToken(tokenStr string) (*jwtClaims, error) {
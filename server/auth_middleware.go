@@ -1,46 +1,203 @@
 package server
 
 import (
+	"backend-avanzada/models"
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+
+	"github.com/gorilla/mux"
 )
 
 type ctxKey string
 
 const (
-	ctxUserID ctxKey = "uid"
-	ctxEmail  ctxKey = "email"
-	ctxRole   ctxKey = "role"
+	ctxUserID      ctxKey = "uid"
+	ctxEmail       ctxKey = "email"
+	ctxRole        ctxKey = "role"
+	ctxAlchemistID ctxKey = "alchemist_id"
+	ctxClientIP    ctxKey = "client_ip"
 )
 
+const (
+	roleAlchemist  = "ALCHEMIST"
+	roleSupervisor = "SUPERVISOR"
+)
+
+var (
+	errMissingToken = errors.New("missing bearer token")
+	errInvalidToken = errors.New("invalid token")
+	errForbidden    = errors.New("forbidden")
+)
+
+// routePolicies limita rutas concretas (método + plantilla de mux) a ciertos roles.
+// Las rutas protegidas que no aparecen aquí solo exigen un token válido.
+var routePolicies = map[string][]string{
+	http.MethodPatch + " /transmutations/{id}": {roleSupervisor},
+	http.MethodDelete + " /alchemists/{id}":    {roleSupervisor},
+	http.MethodDelete + " /materials/{id}":     {roleSupervisor},
+	http.MethodGet + " /audits":                {roleSupervisor},
+
+	http.MethodGet + " /users":                {roleSupervisor},
+	http.MethodPost + " /users":               {roleSupervisor},
+	http.MethodGet + " /users/{id}":           {roleSupervisor},
+	http.MethodPatch + " /users/{id}":         {roleSupervisor},
+	http.MethodDelete + " /users/{id}":        {roleSupervisor},
+	http.MethodPost + " /users/invitations":   {roleSupervisor},
+	http.MethodPost + " /users/{id}/unlock":   {roleSupervisor},
+	http.MethodDelete + " /users/{id}/2fa":    {roleSupervisor},
+	http.MethodPost + " /admin/config/reload": {roleSupervisor},
+
+	http.MethodGet + " /webhooks":                 {roleSupervisor},
+	http.MethodPost + " /webhooks":                {roleSupervisor},
+	http.MethodGet + " /webhooks/{id}":            {roleSupervisor},
+	http.MethodPut + " /webhooks/{id}":            {roleSupervisor},
+	http.MethodDelete + " /webhooks/{id}":         {roleSupervisor},
+	http.MethodPost + " /webhooks/{id}/test":      {roleSupervisor},
+	http.MethodGet + " /webhooks/{id}/deliveries": {roleSupervisor},
+}
+
 func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ah := r.Header.Get("Authorization")
-		if !strings.HasPrefix(strings.ToLower(ah), "bearer ") {
-			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		token := bearerToken(r)
+		if token == "" {
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errMissingToken)
 			return
 		}
-		token := strings.TrimSpace(strings.TrimPrefix(ah, "Bearer"))
-		claims, err := parseToken(token)
+		claims, err := s.authenticateToken(token)
+		if errors.Is(err, errTwoFactorSetupRequired) && twoFactorSetupRoutes[r.Method+" "+r.URL.Path] {
+			err = nil
+		}
 		if err != nil {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+			if errors.Is(err, errTwoFactorSetupRequired) {
+				s.HandleError(w, http.StatusForbidden, r.URL.Path, err)
+				return
+			}
+			if errors.Is(err, errTokenRevoked) || errors.Is(err, errAccountDisabled) {
+				s.HandleError(w, http.StatusUnauthorized, r.URL.Path, err)
+				return
+			}
+			if !errors.Is(err, errInvalidToken) {
+				s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+				return
+			}
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidToken)
 			return
 		}
 		ctx := context.WithValue(r.Context(), ctxUserID, claims.UserID)
 		ctx = context.WithValue(ctx, ctxEmail, claims.Email)
 		ctx = context.WithValue(ctx, ctxRole, claims.Role)
+		if claims.AlchemistID != nil {
+			ctx = context.WithValue(ctx, ctxAlchemistID, *claims.AlchemistID)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func RoleOnly(role string, next http.Handler) http.Handler {
+// authenticateToken valida un JWT de acceso; lo usan AuthMiddleware y /ws.
+// Devuelve errInvalidToken, errTokenRevoked, errAccountDisabled o el error de la base de datos.
+// Con errTwoFactorSetupRequired también devuelve los claims, para las rutas de activación.
+func (s *Server) authenticateToken(token string) (*jwtClaims, error) {
+	claims, _, err := s.authenticateUser(token)
+	return claims, err
+}
+
+// authenticateUser es authenticateToken devolviendo además el usuario leído.
+func (s *Server) authenticateUser(token string) (*jwtClaims, *models.User, error) {
+	if strings.TrimSpace(token) == "" {
+		return nil, nil, errMissingToken
+	}
+	claims, err := parseToken(token)
+	if err != nil || claims.ID == "" {
+		return nil, nil, errInvalidToken
+	}
+	revoked, err := s.TokenRepository.IsRevoked(claims.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, errTokenRevoked
+	}
+	user, err := s.UserRepository.FindById(claims.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || user.Disabled {
+		return nil, nil, errAccountDisabled
+	}
+	if s.twoFactorSetupPending(user) {
+		return claims, user, errTwoFactorSetupRequired
+	}
+	return claims, user, nil
+}
+
+// bearerToken lee el token del header Authorization. Los navegadores no pueden
+// enviar headers al abrir un WebSocket ni un EventSource, así que ahí se acepta ?token=.
+func bearerToken(r *http.Request) string {
+	ah := r.Header.Get("Authorization")
+	if strings.HasPrefix(strings.ToLower(ah), "bearer ") {
+		return strings.TrimSpace(ah[len("bearer "):])
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return strings.TrimSpace(r.URL.Query().Get("token"))
+	}
+	return ""
+}
+
+// RoutePolicy aplica routePolicies sobre la ruta que resolvió mux.
+func (s *Server) RoutePolicy(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		val := r.Context().Value(ctxRole)
-		if val == nil || val.(string) != role {
-			http.Error(w, "forbidden", http.StatusForbidden)
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
 			return
 		}
-		next.ServeHTTP(w, r)
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		roles, ok := routePolicies[r.Method+" "+tpl]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.RoleOnly(next, roles...).ServeHTTP(w, r)
 	})
 }
+
+func (s *Server) RoleOnly(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := roleFromContext(r.Context())
+		for _, allowed := range roles {
+			if role == allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		s.HandleError(w, http.StatusForbidden, r.URL.Path, errForbidden)
+	})
+}
+
+func roleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(ctxRole).(string)
+	return role
+}
+
+// alchemistScope indica si el usuario del request solo puede operar sobre su
+// propio alquimista y cuál es. Un ALCHEMIST sin alquimista vinculado queda
+// restringido al id 0, que no existe.
+func alchemistScope(r *http.Request) (uint, bool) {
+	if roleFromContext(r.Context()) != roleAlchemist {
+		return 0, false
+	}
+	id, _ := r.Context().Value(ctxAlchemistID).(uint)
+	return id, true
+}
+
+func canAccessAlchemist(r *http.Request, alchemistID uint) bool {
+	own, restricted := alchemistScope(r)
+	return !restricted || own == alchemistID
+}
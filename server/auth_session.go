@@ -0,0 +1,140 @@
+package server
+
+import (
+	"backend-avanzada/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	refreshTokenBytes            = 32
+	auditActionRefreshTokenReuse = "REFRESH_TOKEN_REUSE"
+	auditEntityUser              = "user"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	errTokenRevoked        = errors.New("token revoked")
+)
+
+// issueSession emite un access token y un refresh token. familyID vacío abre
+// una familia nueva (login); al rotar se conserva la del token usado.
+func (s *Server) issueSession(user *models.User, familyID string) (*authResp, error) {
+	access, err := generateToken(user.ID, user.Email, user.Role, user.AlchemistID, accessTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+	if familyID == "" {
+		if familyID, err = randomToken(16); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.TokenRepository.SaveRefresh(&models.RefreshToken{
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		TokenHash:       hashRefreshToken(refresh),
+		ExpiresAt:       time.Now().Add(refreshTokenTTL()),
+		AccessJTI:       access.JTI,
+		AccessExpiresAt: access.ExpiresAt,
+	}); err != nil {
+		return nil, err
+	}
+	return &authResp{
+		Token:        access.Token,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(access.ExpiresAt).Seconds()),
+
+		TwoFactorSetupRequired: s.twoFactorSetupPending(user),
+	}, nil
+}
+
+// rotateSession canjea un refresh token por un par nuevo. Si el token ya había
+// sido usado se asume robado y se revoca toda su familia.
+func (s *Server) rotateSession(refresh string, actor auditActor) (*authResp, error) {
+	stored, err := s.TokenRepository.FindRefreshByHash(hashRefreshToken(refresh))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errInvalidRefreshToken
+	}
+	now := time.Now()
+	if stored.RotatedAt != nil {
+		return nil, s.revokeReusedFamily(stored, actor, now)
+	}
+	// revocado por logout o por un reuso anterior de la familia
+	if stored.RevokedAt != nil || !stored.ExpiresAt.After(now) {
+		return nil, errInvalidRefreshToken
+	}
+	consumed, err := s.TokenRepository.ConsumeRefresh(stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		// otra petición lo rotó primero: también es un reuso
+		return nil, s.revokeReusedFamily(stored, actor, now)
+	}
+	user, err := s.UserRepository.FindById(stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errInvalidRefreshToken
+	}
+	if user.Disabled {
+		return nil, errAccountDisabled
+	}
+	return s.issueSession(user, stored.FamilyID)
+}
+
+func (s *Server) revokeReusedFamily(stored *models.RefreshToken, actor auditActor, now time.Time) error {
+	if err := s.TokenRepository.RevokeFamily(stored.FamilyID, now); err != nil {
+		return err
+	}
+	// /auth/refresh no lleva access token: el actor es el dueño del refresh
+	if actor.UserID == nil {
+		uid := stored.UserID
+		actor.UserID = &uid
+	}
+	if err := s.saveAudit(actor, &models.Audit{
+		Action:      auditActionRefreshTokenReuse,
+		Entity:      auditEntityUser,
+		EntityID:    stored.UserID,
+		Description: fmt.Sprintf("Reuso de refresh token del usuario #%d; se revocó la sesión completa", stored.UserID),
+	}); err != nil {
+		s.logger.Warn("no se pudo auditar el reuso de refresh token", "error", err)
+	}
+	return errRefreshTokenReused
+}
+
+// endSession revoca el access token actual y, si se indica, la familia del
+// refresh token (solo si pertenece al mismo usuario) o todas las sesiones.
+func (s *Server) endSession(claims *jwtClaims, refresh string, all bool) error {
+	now := time.Now()
+	if claims.ExpiresAt != nil {
+		if err := s.TokenRepository.RevokeAccess(claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+	}
+	if all {
+		return s.TokenRepository.RevokeUser(claims.UserID, now)
+	}
+	if refresh == "" {
+		return nil
+	}
+	stored, err := s.TokenRepository.FindRefreshByHash(hashRefreshToken(refresh))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.UserID != claims.UserID {
+		return errInvalidRefreshToken
+	}
+	return s.TokenRepository.RevokeFamily(stored.FamilyID, now)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"backend-avanzada/config"
+	"backend-avanzada/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const (
+	auditActionConfigReloaded = "CONFIG_RELOADED"
+	auditEntityConfig         = "config"
+)
+
+type configReloadResp struct {
+	Changes []config.Change `json:"changes"`
+	// campos que cambiaron en el archivo pero solo se aplican al reiniciar
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// Config devuelve la configuración vigente. Cada recarga la reemplaza entera,
+// así que quien necesite varios campos coherentes debe leerla una sola vez.
+func (s *Server) Config() *config.Config {
+	return s.cfg.Load()
+}
+
+// reloadConfig vuelve a leer y validar la configuración (archivo y entorno) y
+// la publica de una vez. Si algo es inválido se conserva la vigente.
+func (s *Server) reloadConfig(actor auditActor) (*configReloadResp, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	next, err := config.Load(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+	current := s.Config()
+	resp := &configReloadResp{RestartRequired: config.KeepStartupFields(current, next)}
+	resp.Changes = config.Diff(current, next)
+	if len(resp.Changes) == 0 {
+		return resp, nil
+	}
+	s.cfg.Store(next)
+	if current.DailyCheckHour != next.DailyCheckHour {
+		select {
+		case s.dailyReschedule <- struct{}{}:
+		default:
+		}
+	}
+
+	parts := make([]string, 0, len(resp.Changes))
+	for _, c := range resp.Changes {
+		parts = append(parts, fmt.Sprintf("%s: %s → %s", c.Field, c.Old, c.New))
+	}
+	if err := s.saveAudit(actor, &models.Audit{
+		Action:      auditActionConfigReloaded,
+		Entity:      auditEntityConfig,
+		Description: "Configuración recargada: " + strings.Join(parts, "; "),
+	}); err != nil {
+		s.logger.Warn("no se pudo auditar la recarga de configuración", "error", err)
+	}
+	s.logger.Info("configuración recargada", "changes", len(resp.Changes))
+	return resp, nil
+}
+
+// watchReloadSignal recarga la configuración al recibir SIGHUP.
+func (s *Server) watchReloadSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			resp, err := s.reloadConfig(systemActor)
+			if err != nil {
+				s.logger.Error("SIGHUP: configuración inválida, se mantiene la actual", "error", err)
+				continue
+			}
+			if len(resp.RestartRequired) > 0 {
+				s.logger.Warn("SIGHUP: hay cambios que requieren reiniciar", "fields", resp.RestartRequired)
+			}
+		}
+	}()
+}
+
+// HandleConfigReload recarga la configuración a pedido de un supervisor.
+func (s *Server) HandleConfigReload(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.reloadConfig(actorFromRequest(r))
+	if err != nil {
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -2,14 +2,20 @@ package server
 
 import (
 	"backend-avanzada/api"
+	"backend-avanzada/logger"
 	"encoding/json"
 	"net/http"
 )
 
 var statusMap = map[int]string{
 	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
 	404: "Not Found",
 	405: "Method Not Allowed",
+	409: "Conflict",
+	423: "Locked",
+	429: "Too Many Requests",
 	500: "Internal Server Error",
 	200: "OK",
 	201: "Created",
@@ -29,5 +35,8 @@ func (s *Server) HandleError(w http.ResponseWriter, statusCode int, path string,
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	w.Write(response)
-	s.logger.Error(statusCode, path, cause)
+	// la causa sale en la línea de acceso del request
+	if !logger.RecordError(w, cause) {
+		s.logger.Error("request failed", "status", statusCode, "path", path, "error", cause)
+	}
 }
@@ -2,16 +2,27 @@ package server
 
 import (
 	"backend-avanzada/models"
+	"backend-avanzada/repository"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
+)
+
+var (
+	errEmailRegistered    = repository.ErrEmailRegistered
+	errInvalidCredentials = errors.New("invalid credentials")
 )
 
 type registerReq struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
-	Role     string `json:"role"` // "ALCHEMIST" | "SUPERVISOR"
+	Role     string `json:"role"` // opcional; solo "ALCHEMIST" sin invitación
 	AlcID    *uint  `json:"alchemist_id"`
+	// InvitationToken lo entrega un SUPERVISOR para registrarse con otro rol
+	InvitationToken string `json:"invitation_token"`
 }
 
 type loginReq struct {
@@ -20,80 +31,273 @@ type loginReq struct {
 }
 
 type authResp struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// segundos de vida del access token
+	ExpiresIn int `json:"expires_in"`
+	// la cuenta solo puede usar /auth/2fa/setup y /verify hasta activar 2FA
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type changePasswordReq struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type forgotPasswordReq struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordReq struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
 }
 
+type logoutReq struct {
+	RefreshToken string `json:"refresh_token"`
+	// All cierra todas las sesiones del usuario
+	All bool `json:"all"`
+}
+
+// HandleRegister crea cuentas ALCHEMIST; otros roles requieren invitation_token.
 func (s *Server) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	var req registerReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
 		return
 	}
-	email := strings.TrimSpace(req.Email)
-	role := strings.ToUpper(strings.TrimSpace(req.Role))
-	if email == "" || req.Password == "" || (role != "ALCHEMIST" && role != "SUPERVISOR") {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	u := newUser{
+		Email:       req.Email,
+		Password:    req.Password,
+		Role:        strings.ToUpper(strings.TrimSpace(req.Role)),
+		AlchemistID: req.AlcID,
 	}
-	exists, _ := s.UserRepository.FindByEmail(email)
-	if exists != nil {
-		http.Error(w, "email already registered", http.StatusConflict)
-		return
+	var invitation *models.Invitation
+	if token := strings.TrimSpace(req.InvitationToken); token != "" {
+		var err error
+		if invitation, err = s.redeemInvitation(token, &u); err != nil {
+			s.handleUserError(w, r, err)
+			return
+		}
+	} else {
+		if u.Role == "" {
+			u.Role = roleAlchemist
+		}
+		if u.Role != roleAlchemist {
+			s.handleUserError(w, r, errRoleRequiresInvitation)
+			return
+		}
 	}
-	hash, err := hashPassword(req.Password)
+	user, err := s.createUser(u, invitation)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		s.handleUserError(w, r, err)
 		return
 	}
-	user := &models.User{
-		Email:        email,
-		PasswordHash: hash,
-		Role:         role,
-		AlchemistID:  req.AlcID,
+	description := fmt.Sprintf("Usuario %s registrado con rol %s", user.Email, user.Role)
+	if invitation != nil {
+		description += fmt.Sprintf(" usando la invitación #%d", invitation.ID)
 	}
-	if _, err := s.UserRepository.Save(user); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	token, err := generateToken(user.ID, user.Email, user.Role, 24) // 24h
+	actor := actorFromRequest(r)
+	actor.UserID, actor.Email, actor.Role = &user.ID, user.Email, user.Role
+	s.auditUser(actor, auditActionUserRegistered, user.ID, description)
+
+	session, err := s.issueSession(user, "")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(authResp{Token: token})
+	_ = json.NewEncoder(w).Encode(session)
 }
 
 func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	var req loginReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
 		return
 	}
 	email := strings.TrimSpace(req.Email)
 	if email == "" || req.Password == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, errInvalidUserInput)
 		return
 	}
 	user, err := s.UserRepository.FindByEmail(email)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	actor := actorFromRequest(r)
+	now := time.Now()
+	if wait, err := s.checkLoginAllowed(email, actor.SourceIP, user, now); err != nil {
+		s.writeLoginBlocked(w, r, wait, err)
+		return
+	}
+	if user == nil || checkPasswordHash(user.PasswordHash, req.Password) != nil {
+		// la demora progresiva frena la fuerza bruta sin bloquear todavía
+		if waitLoginDelay(r, s.recordLoginFailure(email, user, actor, now)) {
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidCredentials)
+		}
+		return
+	}
+	if user.Disabled {
+		s.HandleError(w, http.StatusForbidden, r.URL.Path, errAccountDisabled)
+		return
+	}
+	if user.TOTPEnabled {
+		// los fallos se limpian recién cuando se completa el segundo paso
+		challenge, err := s.startLoginChallenge(user)
+		if err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(challenge)
+		return
+	}
+	if err := s.clearLoginFailures(user); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	session, err := s.issueSession(user, "")
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+// HandleRefresh rota el refresh token: el usado queda inválido y se devuelve un par nuevo.
+func (s *Server) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.RefreshToken) == "" {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, errInvalidRefreshToken)
+		return
+	}
+	session, err := s.rotateSession(strings.TrimSpace(req.RefreshToken), actorFromRequest(r))
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) || errors.Is(err, errAccountDisabled) {
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, err)
+			return
+		}
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+// HandleLogout revoca el access token del request y opcionalmente el refresh
+// token enviado (o todas las sesiones con "all": true).
+func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	var req logoutReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+	}
+	claims, err := parseToken(bearerToken(r))
+	if err != nil {
+		s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidToken)
+		return
+	}
+	if err := s.endSession(claims, strings.TrimSpace(req.RefreshToken), req.All); err != nil {
+		if errors.Is(err, errInvalidRefreshToken) {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleChangePassword cambia la contraseña del usuario autenticado. Cierra
+// todas sus sesiones y devuelve un par de tokens nuevo para la actual.
+func (s *Server) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	uid, _ := r.Context().Value(ctxUserID).(uint)
+	user, err := s.UserRepository.FindById(uid)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
 	if user == nil {
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidToken)
+		return
+	}
+	// la contraseña actual se trata como un login: cuenta fallos y bloquea
+	actor := actorFromRequest(r)
+	now := time.Now()
+	if wait, err := s.checkLoginAllowed(user.Email, actor.SourceIP, user, now); err != nil {
+		s.writeLoginBlocked(w, r, wait, err)
+		return
+	}
+	if checkPasswordHash(user.PasswordHash, req.CurrentPassword) != nil {
+		if waitLoginDelay(r, s.recordLoginFailure(user.Email, user, actor, now)) {
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errWrongCurrentPassword)
+		}
 		return
 	}
-	if err := checkPasswordHash(user.PasswordHash, req.Password); err != nil {
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+	if err := s.clearLoginFailures(user); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	token, err := generateToken(user.ID, user.Email, user.Role, 24)
+	if err := s.changePassword(user, req.NewPassword); err != nil {
+		if errors.Is(err, errWeakPassword) || errors.Is(err, errPasswordUnchanged) {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	s.auditUser(actor, auditActionPasswordChanged, user.ID, fmt.Sprintf("Contraseña de %s cambiada", user.Email))
+	session, err := s.issueSession(user, "")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(authResp{Token: token})
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+// HandleForgotPassword responde 202 exista o no el email.
+func (s *Server) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, errInvalidUserInput)
+		return
+	}
+	if err := s.requestPasswordReset(r.Context(), req.Email, actorFromRequest(r)); err != nil {
+		s.logger.FromContext(r.Context()).Warn("no se pudo procesar la recuperación de contraseña", "error", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Token) == "" {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, errInvalidResetToken)
+		return
+	}
+	if err := s.resetPassword(strings.TrimSpace(req.Token), req.NewPassword, actorFromRequest(r)); err != nil {
+		if errors.Is(err, errInvalidResetToken) || errors.Is(err, errWeakPassword) {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"backend-avanzada/api"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusDegraded = "degraded"
+
+	readinessTimeout = 2 * time.Second
+)
+
+var (
+	errHubNotRunning   = errors.New("hub goroutine is not running")
+	errDailyNotRunning = errors.New("daily check loop is not running")
+	errDBNotReady      = errors.New("database not initialized")
+)
+
+// HandleHealthz responde mientras el proceso esté vivo; no toca la base.
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, &api.HealthResponseDto{Estado: healthStatusOK})
+}
+
+// HandleReadyz revisa la base, las migraciones, el hub y el bucle de
+// verificaciones diarias. Si algo falla responde 503 con el detalle.
+func (s *Server) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	resp := &api.HealthResponseDto{Estado: healthStatusOK, Componentes: map[string]api.ComponentHealthDto{}}
+	check := func(name string, err error) {
+		c := api.ComponentHealthDto{Estado: healthStatusOK}
+		if err != nil {
+			c = api.ComponentHealthDto{Estado: healthStatusDegraded, Detalle: err.Error()}
+			resp.Estado = healthStatusDegraded
+		}
+		resp.Componentes[name] = c
+	}
+
+	dbErr := s.pingDB(ctx)
+	check("database", dbErr)
+	if dbErr != nil {
+		check("migrations", dbErr)
+	} else {
+		check("migrations", s.checkMigrations(ctx))
+	}
+	if s.WsHub == nil || !s.WsHub.Running() {
+		check("ws_hub", errHubNotRunning)
+	} else {
+		check("ws_hub", nil)
+	}
+	if !s.dailyRunning.Load() {
+		check("daily_checks", errDailyNotRunning)
+	} else {
+		check("daily_checks", nil)
+	}
+
+	status := http.StatusOK
+	if resp.Estado != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, resp)
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp *api.HealthResponseDto) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) pingDB(ctx context.Context) error {
+	if s.DB == nil {
+		return errDBNotReady
+	}
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// checkMigrations confirma que cada tabla de migratedModels exista y tenga
+// todas las columnas del modelo.
+func (s *Server) checkMigrations(ctx context.Context) error {
+	db := s.DB.WithContext(ctx)
+	migrator := db.Migrator()
+	var missing []string
+	for _, model := range migratedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+		if !migrator.HasTable(model) {
+			missing = append(missing, table)
+			continue
+		}
+		columns, err := migrator.ColumnTypes(model)
+		if err != nil {
+			return err
+		}
+		present := make(map[string]bool, len(columns))
+		for _, c := range columns {
+			present[strings.ToLower(c.Name())] = true
+		}
+		for _, name := range stmt.Schema.DBNames {
+			if !present[strings.ToLower(name)] {
+				missing = append(missing, table+"."+name)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing migrations: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
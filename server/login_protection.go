@@ -0,0 +1,252 @@
+package server
+
+import (
+	"backend-avanzada/config"
+	"backend-avanzada/models"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoginMaxFailures   = 5
+	defaultLoginWindow        = 15 * time.Minute
+	defaultLoginLockout       = 15 * time.Minute
+	defaultLoginIPMaxFailures = 50
+	defaultLoginDelayBase     = 250 * time.Millisecond
+	defaultLoginDelayMax      = 3 * time.Second
+	// por encima de esta cantidad de claves se purgan las vencidas
+	loginThrottlePruneSize = 10000
+
+	auditActionLoginFailed     = "LOGIN_FAILED"
+	auditActionAccountLocked   = "ACCOUNT_LOCKED"
+	auditActionAccountUnlocked = "ACCOUNT_UNLOCKED"
+)
+
+var (
+	errAccountLocked   = errors.New("account temporarily locked after too many failed logins")
+	errTooManyAttempts = errors.New("too many failed logins from this address")
+)
+
+// loginAttempts cuenta los fallos de una clave (email o IP) dentro de la ventana.
+type loginAttempts struct {
+	failures    int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// loginThrottle guarda en memoria los fallos por email y por IP. El bloqueo de
+// cuentas existentes además se persiste en User.LockedUntil.
+type loginThrottle struct {
+	mu      sync.Mutex
+	entries map[string]*loginAttempts
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{entries: map[string]*loginAttempts{}}
+}
+
+func loginEmailKey(email string) string { return "email:" + strings.ToLower(strings.TrimSpace(email)) }
+func loginIPKey(ip string) string       { return "ip:" + ip }
+
+// lockedFor devuelve cuánto falta para que se libere la clave (0 si no está bloqueada).
+func (t *loginThrottle) lockedFor(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.entries[key]; ok && e.lockedUntil.After(now) {
+		return e.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// fail registra un fallo. Devuelve los fallos acumulados en la ventana y si
+// este fallo provocó el bloqueo.
+func (t *loginThrottle) fail(key string, now time.Time, window time.Duration, max int, lockout time.Duration) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.entries) > loginThrottlePruneSize {
+		t.prune(now, window)
+	}
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.windowStart) > window {
+		e = &loginAttempts{windowStart: now}
+		t.entries[key] = e
+	}
+	e.failures++
+	if e.failures >= max && !e.lockedUntil.After(now) {
+		e.lockedUntil = now.Add(lockout)
+		// la ventana vuelve a empezar cuando termina el bloqueo
+		e.failures = 0
+		e.windowStart = e.lockedUntil
+		return max, true
+	}
+	return e.failures, false
+}
+
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+func (t *loginThrottle) prune(now time.Time, window time.Duration) {
+	for key, e := range t.entries {
+		if !e.lockedUntil.After(now) && now.Sub(e.windowStart) > window {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// loginSettings aplica los valores por defecto a config.LoginProtection.
+type loginSettings struct {
+	maxFailures   int
+	window        time.Duration
+	lockout       time.Duration
+	ipMaxFailures int
+	delayBase     time.Duration
+	delayMax      time.Duration
+}
+
+func (s *Server) loginSettings() loginSettings {
+	var cfg config.LoginProtection
+	if s.Config() != nil {
+		cfg = s.Config().LoginProtection
+	}
+	ls := loginSettings{
+		maxFailures:   defaultLoginMaxFailures,
+		window:        defaultLoginWindow,
+		lockout:       defaultLoginLockout,
+		ipMaxFailures: defaultLoginIPMaxFailures,
+		delayBase:     defaultLoginDelayBase,
+		delayMax:      defaultLoginDelayMax,
+	}
+	if cfg.MaxFailures > 0 {
+		ls.maxFailures = cfg.MaxFailures
+	}
+	if cfg.WindowMinutes > 0 {
+		ls.window = time.Duration(cfg.WindowMinutes) * time.Minute
+	}
+	if cfg.LockoutMinutes > 0 {
+		ls.lockout = time.Duration(cfg.LockoutMinutes) * time.Minute
+	}
+	if cfg.IPMaxFailures > 0 {
+		ls.ipMaxFailures = cfg.IPMaxFailures
+	}
+	if cfg.DelayBaseMs > 0 {
+		ls.delayBase = time.Duration(cfg.DelayBaseMs) * time.Millisecond
+	}
+	if cfg.DelayMaxMs > 0 {
+		ls.delayMax = time.Duration(cfg.DelayMaxMs) * time.Millisecond
+	}
+	return ls
+}
+
+// delay es la espera progresiva del n-ésimo fallo: base * 2^(n-1), con tope.
+func (ls loginSettings) delay(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	d := float64(ls.delayBase) * math.Pow(2, float64(failures-1))
+	if d > float64(ls.delayMax) {
+		return ls.delayMax
+	}
+	return time.Duration(d)
+}
+
+// checkLoginAllowed rechaza el intento si la IP o el email están bloqueados.
+// user puede ser nil: los emails inexistentes se bloquean igual para no
+// revelar qué cuentas existen.
+func (s *Server) checkLoginAllowed(email, ip string, user *models.User, now time.Time) (time.Duration, error) {
+	if wait := s.loginThrottle.lockedFor(loginIPKey(ip), now); wait > 0 {
+		return wait, errTooManyAttempts
+	}
+	wait := s.loginThrottle.lockedFor(loginEmailKey(email), now)
+	if user != nil && user.LockedUntil != nil && user.LockedUntil.Sub(now) > wait {
+		wait = user.LockedUntil.Sub(now)
+	}
+	if wait > 0 {
+		return wait, errAccountLocked
+	}
+	return 0, nil
+}
+
+// writeLoginBlocked responde 429 si la IP está bloqueada o 423 si lo está la cuenta.
+func (s *Server) writeLoginBlocked(w http.ResponseWriter, r *http.Request, wait time.Duration, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	status := http.StatusLocked
+	if errors.Is(err, errTooManyAttempts) {
+		status = http.StatusTooManyRequests
+	}
+	s.HandleError(w, status, r.URL.Path, err)
+}
+
+// waitLoginDelay aplica la demora progresiva; devuelve false si el cliente se fue.
+func waitLoginDelay(r *http.Request, delay time.Duration) bool {
+	select {
+	case <-time.After(delay):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// recordLoginFailure cuenta el fallo por email e IP, audita y, al llegar al
+// máximo, bloquea la cuenta. Devuelve la demora a aplicar antes de responder.
+func (s *Server) recordLoginFailure(email string, user *models.User, actor auditActor, now time.Time) time.Duration {
+	ls := s.loginSettings()
+	failures, locked := s.loginThrottle.fail(loginEmailKey(email), now, ls.window, ls.maxFailures, ls.lockout)
+	if _, ipLocked := s.loginThrottle.fail(loginIPKey(actor.SourceIP), now, ls.window, ls.ipMaxFailures, ls.lockout); ipLocked {
+		s.logger.Warn("IP bloqueada por intentos de login fallidos", "ip", actor.SourceIP, "failures", ls.ipMaxFailures)
+	}
+
+	var userID uint
+	if user != nil {
+		userID = user.ID
+	}
+	actor.Email = strings.TrimSpace(email)
+	s.auditUser(actor, auditActionLoginFailed, userID,
+		fmt.Sprintf("Login fallido para %s desde %s (%d/%d)", actor.Email, actor.SourceIP, failures, ls.maxFailures))
+	if !locked {
+		return ls.delay(failures)
+	}
+	if user != nil {
+		until := now.Add(ls.lockout).UTC()
+		user.LockedUntil = &until
+		if err := s.UserRepository.SetLockedUntil(user.ID, &until); err != nil {
+			s.logger.Warn("no se pudo guardar el bloqueo del usuario", "user_id", user.ID, "error", err)
+		}
+	}
+	s.auditUser(actor, auditActionAccountLocked, userID,
+		fmt.Sprintf("Cuenta %s bloqueada %d minutos tras %d intentos fallidos", actor.Email, int(ls.lockout.Minutes()), ls.maxFailures))
+	return ls.delay(failures)
+}
+
+// clearLoginFailures olvida los fallos del email tras un login correcto.
+func (s *Server) clearLoginFailures(user *models.User) error {
+	s.loginThrottle.reset(loginEmailKey(user.Email))
+	if user.LockedUntil == nil {
+		return nil
+	}
+	user.LockedUntil = nil
+	return s.UserRepository.SetLockedUntil(user.ID, nil)
+}
+
+// unlockUser levanta el bloqueo por intentos fallidos. Devuelve false si la
+// cuenta no estaba bloqueada.
+func (s *Server) unlockUser(user *models.User, actor auditActor) (bool, error) {
+	now := time.Now()
+	wasLocked := s.loginThrottle.lockedFor(loginEmailKey(user.Email), now) > 0 ||
+		(user.LockedUntil != nil && user.LockedUntil.After(now))
+	if err := s.clearLoginFailures(user); err != nil {
+		return false, err
+	}
+	if wasLocked {
+		s.auditUser(actor, auditActionAccountUnlocked, user.ID, fmt.Sprintf("Cuenta %s desbloqueada", user.Email))
+	}
+	return wasLocked, nil
+}
@@ -3,26 +3,50 @@ package server
 import (
 	"backend-avanzada/api"
 	"backend-avanzada/models"
+	"backend-avanzada/repository"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
+var materialSortFields = map[string]string{
+	"id":    "id",
+	"name":  "name",
+	"cost":  "cost",
+	"stock": "stock",
+}
+
 func (s *Server) HandleMaterials(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		list, err := s.MaterialRepository.FindAll()
+		q := r.URL.Query()
+		spec, pageReq, err := parseListQuery(q, materialSortFields)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		if name := strings.TrimSpace(q.Get("q")); name != "" {
+			spec.Filters = append(spec.Filters, repository.Filter{Column: "name", Op: repository.OpContains, Value: name})
+		}
+		page, err := s.MaterialRepository.FindPage(spec)
+		if err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
 		resp := []*api.MaterialResponseDto{}
-		for _, m := range list {
+		var lastID uint
+		for _, m := range page.Items {
 			resp = append(resp, m.ToResponseDto())
+			lastID = m.ID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		writePageHeaders(w, pageReq, page.Total, page.HasMore, lastID)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		}
-		json.NewEncoder(w).Encode(resp)
 		return
 
 	case http.MethodPost:
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"backend-avanzada/metrics"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const metricsNamespace = "ametris_"
+
+var errMetricsDisabled = errors.New("metrics are not published on this address: set metrics.token or use metrics.address")
+
+// rutas de larga duración: se cuentan pero no entran en el histograma de latencia
+var streamingRoutes = map[string]bool{
+	"/ws":     true,
+	"/events": true,
+}
+
+// serverMetrics son las métricas que se actualizan al ocurrir algo; las que
+// reflejan estado (transmutaciones, tareas, clientes) se calculan al leerlas.
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	httpRequests       *metrics.CounterVec
+	httpDuration       *metrics.HistogramVec
+	dailyCheckRuns     *metrics.CounterVec
+	dailyCheckDuration *metrics.HistogramVec
+	dbErrors           *metrics.CounterVec
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		httpRequests: r.NewCounterVec(metricsNamespace+"http_requests_total",
+			"Requests HTTP atendidos por ruta, método y status.", "method", "route", "status"),
+		httpDuration: r.NewHistogramVec(metricsNamespace+"http_request_duration_seconds",
+			"Latencia de los requests HTTP por ruta y método.", metrics.DefaultBuckets, "method", "route"),
+		dailyCheckRuns: r.NewCounterVec(metricsNamespace+"daily_check_runs_total",
+			"Ejecuciones de cada verificación diaria por resultado.", "check", "result"),
+		dailyCheckDuration: r.NewHistogramVec(metricsNamespace+"daily_check_duration_seconds",
+			"Duración de cada verificación diaria.", metrics.DefaultBuckets, "check"),
+		dbErrors: r.NewCounterVec(metricsNamespace+"db_query_errors_total",
+			"Consultas a la base de datos que devolvieron error, por operación.", "operation"),
+	}
+
+	r.NewGaugeVecFunc(metricsNamespace+"transmutations", "Transmutaciones por estado.", "status", func() map[string]float64 {
+		if s.TransmutationRepository == nil {
+			return nil
+		}
+		counts, err := s.TransmutationRepository.CountByStatus()
+		if err != nil {
+			s.logger.Warn("no se pudieron contar las transmutaciones", "error", err)
+			return nil
+		}
+		out := make(map[string]float64, len(counts))
+		for status, n := range counts {
+			out[status] = float64(n)
+		}
+		return out
+	})
+	r.NewGaugeFunc(metricsNamespace+"task_queue_active_tasks", "Tareas programadas o en ejecución en la cola.", func() float64 {
+		return float64(s.taskQueue.Active())
+	})
+	r.NewGaugeVecFunc(metricsNamespace+"ws_connected_clients", "Clientes conectados al hub por transporte.", "transport", func() map[string]float64 {
+		if s.WsHub == nil {
+			return nil
+		}
+		out := map[string]float64{}
+		for transport, n := range s.WsHub.ClientCounts() {
+			out[transport] = float64(n)
+		}
+		return out
+	})
+	r.NewCounterFunc(metricsNamespace+"ws_dropped_messages_total", "Mensajes del hub descartados por buffer lleno.", func() float64 {
+		if s.WsHub == nil {
+			return 0
+		}
+		return float64(s.WsHub.Dropped())
+	})
+	return m
+}
+
+// instrument cuenta y mide cada request con la plantilla de la ruta de mux,
+// para no crear una serie por id.
+func (s *Server) instrument(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if tpl, err := match.Route.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		m := httpsnoop.CaptureMetrics(router, w, r)
+		s.metrics.httpRequests.Inc(r.Method, route, strconv.Itoa(m.Code))
+		if !streamingRoutes[route] {
+			s.metrics.httpDuration.Observe(m.Duration.Seconds(), r.Method, route)
+		}
+	})
+}
+
+// observeDailyCheck ejecuta una verificación diaria registrando su duración y resultado.
+func (s *Server) observeDailyCheck(check string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	s.metrics.dailyCheckDuration.Observe(time.Since(start).Seconds(), check)
+	s.metrics.dailyCheckRuns.Inc(check, result)
+	return err
+}
+
+// registerDBMetrics cuenta los errores de gorm; "record not found" no es un error.
+func (s *Server) registerDBMetrics(db *gorm.DB) error {
+	record := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				s.metrics.dbErrors.Inc(operation)
+			}
+		}
+	}
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().After("gorm:create").Register("metrics:create", record("create")),
+		cb.Query().After("gorm:query").Register("metrics:query", record("query")),
+		cb.Update().After("gorm:update").Register("metrics:update", record("update")),
+		cb.Delete().After("gorm:delete").Register("metrics:delete", record("delete")),
+		cb.Row().After("gorm:row").Register("metrics:row", record("row")),
+		cb.Raw().After("gorm:raw").Register("metrics:raw", record("raw")),
+	)
+}
+
+// HandleMetrics publica las métricas en formato Prometheus en la dirección
+// pública, solo con metrics.token como Bearer. Sin token configurado responde
+// 404: las métricas quedan en el listener interno de metrics.address.
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	token := s.Config().Metrics.Token
+	if token == "" {
+		s.HandleError(w, http.StatusNotFound, r.URL.Path, errMetricsDisabled)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+		s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidToken)
+		return
+	}
+	s.metrics.registry.Handler().ServeHTTP(w, r)
+}
+
+// startMetricsListener sirve /metrics sin token en metrics.address, pensada
+// para una interfaz interna a la que solo llega Prometheus.
+func (s *Server) startMetricsListener() {
+	addr := s.Config().Metrics.Address
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", s.metrics.registry.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	s.logger.Info("métricas escuchando", "address", addr)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			s.logger.Error("se detuvo el listener de métricas", "address", addr, "error", err)
+		}
+	}()
+}
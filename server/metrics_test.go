@@ -0,0 +1,42 @@
+package server
+
+import (
+	"backend-avanzada/config"
+	"backend-avanzada/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleMetricsRequiresToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{name: "sin token configurado no se publica", header: "Bearer cualquiera", want: http.StatusNotFound},
+		{name: "sin Authorization", token: "s3cr3t", want: http.StatusUnauthorized},
+		{name: "token incorrecto", token: "s3cr3t", header: "Bearer otro", want: http.StatusUnauthorized},
+		{name: "token correcto", token: "s3cr3t", header: "Bearer s3cr3t", want: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{logger: logger.New("text", "error", io.Discard)}
+			s.taskQueue = NewTaskQueue(s.logger)
+			s.metrics = newServerMetrics(s)
+			s.cfg.Store(&config.Config{Metrics: config.MetricsConfig{Token: tc.token}})
+
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			s.HandleMetrics(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
@@ -3,26 +3,87 @@ package server
 import (
 	"backend-avanzada/api"
 	"backend-avanzada/models"
+	"backend-avanzada/repository"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	missionStatusPending    = "PENDING"
+	missionStatusAssigned   = "ASSIGNED"
+	missionStatusInProgress = "IN_PROGRESS"
+	missionStatusCompleted  = "COMPLETED"
+	missionStatusCancelled  = "CANCELLED"
+)
+
+var (
+	errInvalidMissionStatus     = errors.New("invalid mission status")
+	errIllegalMissionTransition = errors.New("illegal mission status transition")
+	errMissionUnassigned        = errors.New("mission has no assigned alchemist")
+	errMissionLimitReached      = errors.New("alchemist reached the maximum of open missions")
+	errAlchemistBusy            = errors.New("alchemist has a transmutation in progress")
+	errMissionStatusChanged     = errors.New("mission status changed concurrently")
+
+	// missionTransitions define el ciclo de vida de una misión; los estados sin
+	// salidas son terminales.
+	missionTransitions = map[string][]string{
+		missionStatusPending:    {missionStatusAssigned, missionStatusCancelled},
+		missionStatusAssigned:   {missionStatusPending, missionStatusInProgress, missionStatusCancelled},
+		missionStatusInProgress: {missionStatusCompleted, missionStatusCancelled},
+		missionStatusCompleted:  {},
+		missionStatusCancelled:  {},
+	}
+
+	// estados con los que se puede crear una misión
+	missionInitialStatuses = map[string]bool{
+		missionStatusPending:  true,
+		missionStatusAssigned: true,
+	}
+)
+
+var missionSortFields = map[string]string{
+	"id":         "id",
+	"title":      "title",
+	"status":     "status",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
 func (s *Server) HandleMissions(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		list, err := s.MissionRepository.FindAll()
+		q := r.URL.Query()
+		spec, pageReq, err := parseListQuery(q, missionSortFields)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		if status := strings.TrimSpace(q.Get("status")); status != "" {
+			spec.Filters = append(spec.Filters, repository.Filter{Column: "status", Op: repository.OpEq, Value: strings.ToUpper(status)})
+		}
+		spec.Filters = append(spec.Filters, filterIfPresent(q, "assigned_to", "assigned_to_id")...)
+		page, err := s.MissionRepository.FindPage(spec)
+		if err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
 		resp := []*api.MissionResponseDto{}
-		for _, m := range list {
+		var lastID uint
+		for _, m := range page.Items {
 			resp = append(resp, m.ToResponseDto())
+			lastID = m.ID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		writePageHeaders(w, pageReq, page.Total, page.HasMore, lastID)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		}
-		json.NewEncoder(w).Encode(resp)
 		return
 
 	case http.MethodPost:
@@ -40,12 +101,25 @@ func (s *Server) HandleMissions(w http.ResponseWriter, r *http.Request) {
 			v := uint(*assignedInput)
 			assigned = &v
 		}
-		status := "PENDING"
-		if req.Estado != nil && *req.Estado != "" {
-			status = *req.Estado
+		status := missionStatusPending
+		if req.Estado != nil && strings.TrimSpace(*req.Estado) != "" {
+			status = normalizeMissionStatus(*req.Estado)
+			if !missionInitialStatuses[status] {
+				s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("%w: %s", errInvalidMissionStatus, *req.Estado))
+				return
+			}
+		}
+		if err := checkMissionAssignee(status, assigned); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
 		}
 		m := &models.Mission{Title: req.Titulo, Description: req.Descripcion, Status: status, AssignedToID: assigned}
-		if _, err := s.MissionRepository.Save(m); err != nil {
+		if assigned != nil {
+			if _, err := s.saveAssignedMission(m, ""); err != nil {
+				s.handleMissionAssignmentError(w, r, err)
+				return
+			}
+		} else if _, err := s.MissionRepository.Save(m); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -56,7 +130,11 @@ func (s *Server) HandleMissions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) HandleMissionsWithId(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	id, err := strconv.Atoi(strings.TrimSpace(mux.Vars(r)["id"]))
+	if err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		m, err := s.MissionRepository.FindById(id)
@@ -74,23 +152,31 @@ func (s *Server) HandleMissionsWithId(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPut:
 		m, err := s.MissionRepository.FindById(id)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
 		if m == nil {
-			w.WriteHeader(http.StatusNotFound)
+			s.HandleError(w, http.StatusNotFound, r.URL.Path, fmt.Errorf("mission %d not found", id))
 			return
 		}
 		var req api.MissionRequestDto
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
 			return
 		}
+		previous := m.Status
+		previousAssignee := m.AssignedToID
+		status := normalizeMissionStatus(m.Status)
+		if req.Estado != nil && strings.TrimSpace(*req.Estado) != "" {
+			status = normalizeMissionStatus(*req.Estado)
+			if err := validateMissionTransition(m.Status, status); err != nil {
+				s.handleMissionTransitionError(w, r, err)
+				return
+			}
+		}
 		m.Title = req.Titulo
 		m.Description = req.Descripcion
-		if req.Estado != nil && *req.Estado != "" {
-			m.Status = *req.Estado
-		}
+		m.Status = status
 		assignedInput := req.AsignadoAID
 		if assignedInput == nil {
 			assignedInput = req.LegacyAsignadoAID
@@ -101,28 +187,266 @@ func (s *Server) HandleMissionsWithId(w http.ResponseWriter, r *http.Request) {
 		} else {
 			m.AssignedToID = nil
 		}
-		if _, err := s.MissionRepository.Save(m); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if err := checkMissionAssignee(m.Status, m.AssignedToID); err != nil {
+			s.HandleError(w, http.StatusConflict, r.URL.Path, err)
 			return
 		}
-		json.NewEncoder(w).Encode(m.ToResponseDto())
+		// evita que Updates restaure la clave desde la relación precargada
+		m.AssignedTo = nil
+		// la edición se validó contra previous: si otro request movió la misión, 409
+		var applied bool
+		if m.AssignedToID != nil && (previousAssignee == nil || *previousAssignee != *m.AssignedToID) {
+			applied, err = s.saveAssignedMission(m, previous)
+			if err != nil {
+				s.handleMissionAssignmentError(w, r, err)
+				return
+			}
+		} else if applied, err = s.MissionRepository.UpdateFrom(m, previous); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		if !applied {
+			s.HandleError(w, http.StatusConflict, r.URL.Path, errMissionStatusChanged)
+			return
+		}
+		if normalizeMissionStatus(previous) != m.Status {
+			if err := s.recordMissionTransition(actorFromRequest(r), m, previous); err != nil {
+				s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.ToResponseDto()); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		}
 		return
 
 	case http.MethodDelete:
 		m, err := s.MissionRepository.FindById(id)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
 		if m == nil {
-			w.WriteHeader(http.StatusNotFound)
+			s.HandleError(w, http.StatusNotFound, r.URL.Path, fmt.Errorf("mission %d not found", id))
 			return
 		}
 		if err := s.MissionRepository.Delete(m); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		if err := s.recordMissionDeletion(actorFromRequest(r), m); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 }
+
+// HandleMissionStatus mueve una misión a otro estado respetando missionTransitions.
+func (s *Server) HandleMissionStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(mux.Vars(r)["id"]))
+	if err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	var req api.MissionStatusRequestDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	if strings.TrimSpace(req.Estado) == "" {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("status is required"))
+		return
+	}
+	m, err := s.MissionRepository.FindById(id)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	status := normalizeMissionStatus(req.Estado)
+	if err := validateMissionTransition(m.Status, status); err != nil {
+		s.handleMissionTransitionError(w, r, err)
+		return
+	}
+	if err := checkMissionAssignee(status, m.AssignedToID); err != nil {
+		s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+		return
+	}
+	previous := m.Status
+	if normalizeMissionStatus(previous) != status {
+		applied, err := s.MissionRepository.UpdateStatusFrom(m.ID, previous, status)
+		if err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		if !applied {
+			s.HandleError(w, http.StatusConflict, r.URL.Path, errMissionStatusChanged)
+			return
+		}
+		m.Status = status
+		if err := s.recordMissionTransition(actorFromRequest(r), m, previous); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.ToResponseDto()); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+}
+
+func normalizeMissionStatus(status string) string {
+	return strings.ToUpper(strings.TrimSpace(status))
+}
+
+// validateMissionTransition acepta quedarse en el mismo estado. Las misiones
+// antiguas con estados fuera del ciclo se tratan como PENDING.
+func validateMissionTransition(from, to string) error {
+	to = normalizeMissionStatus(to)
+	if _, ok := missionTransitions[to]; !ok {
+		return fmt.Errorf("%w: %s", errInvalidMissionStatus, to)
+	}
+	from = normalizeMissionStatus(from)
+	if _, ok := missionTransitions[from]; !ok {
+		from = missionStatusPending
+	}
+	if from == to {
+		return nil
+	}
+	for _, next := range missionTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", errIllegalMissionTransition, from, to)
+}
+
+func checkMissionAssignee(status string, assignedTo *uint) error {
+	if assignedTo == nil && (status == missionStatusAssigned || status == missionStatusInProgress) {
+		return fmt.Errorf("%w: status %s", errMissionUnassigned, status)
+	}
+	return nil
+}
+
+func (s *Server) handleMissionTransitionError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errInvalidMissionStatus) {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+}
+
+// saveAssignedMission crea m (si no tiene id) o la edita desde el estado
+// previous, validando su asignación a m.AssignedToID: que el alquimista exista,
+// que no supere el máximo de misiones abiertas y, si la configuración lo pide,
+// que no tenga una transmutación en curso. Como en startTransmutation, el
+// alquimista queda bloqueado y el conteo va en la misma transacción que la
+// escritura. Devuelve false si otro request cambió el estado antes.
+func (s *Server) saveAssignedMission(m *models.Mission, previous string) (bool, error) {
+	alchemistID := *m.AssignedToID
+	unlock := s.lockAlchemist(alchemistID)
+	defer unlock()
+
+	alch, err := s.AlchemistRepository.FindById(int(alchemistID))
+	if err != nil {
+		return false, err
+	}
+	if alch == nil {
+		return false, fmt.Errorf("%w: %d", errAlchemistNotFound, alchemistID)
+	}
+	if s.Config() != nil && s.Config().MissionRejectBusyAlchemist {
+		busy, err := s.TransmutationRepository.HasActiveForAlchemist(alchemistID, transmutationStatusInProgress)
+		if err != nil {
+			return false, err
+		}
+		if busy {
+			return false, fmt.Errorf("%w: %s", errAlchemistBusy, alch.Name)
+		}
+	}
+
+	maxOpen := defaultMaxOpenMissions
+	if s.Config() != nil && s.Config().MaxOpenMissionsPerAlchemist > 0 {
+		maxOpen = s.Config().MaxOpenMissionsPerAlchemist
+	}
+	open, applied, err := s.MissionRepository.SaveWithinLimit(m, previous, int64(maxOpen), missionTerminalStatuses())
+	if errors.Is(err, repository.ErrOpenMissionLimit) {
+		return false, fmt.Errorf("%w: %s has %d (max %d)", errMissionLimitReached, alch.Name, open, maxOpen)
+	}
+	return applied, err
+}
+
+func (s *Server) handleMissionAssignmentError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errAlchemistNotFound):
+		s.HandleError(w, http.StatusNotFound, r.URL.Path, err)
+	case errors.Is(err, errMissionLimitReached), errors.Is(err, errAlchemistBusy):
+		s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+	default:
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+	}
+}
+
+// missionTerminalStatuses lista los estados sin transiciones de salida.
+func missionTerminalStatuses() []string {
+	terminal := make([]string, 0, 2)
+	for status, next := range missionTransitions {
+		if len(next) == 0 {
+			terminal = append(terminal, status)
+		}
+	}
+	return terminal
+}
+
+func (s *Server) recordMissionTransition(actor auditActor, m *models.Mission, previous string) error {
+	from := normalizeMissionStatus(previous)
+	if from == "" {
+		from = missionStatusPending
+	}
+	if err := s.saveAudit(actor, &models.Audit{
+		Action:      "MISSION_STATUS_UPDATED",
+		Entity:      auditEntityMission,
+		EntityID:    m.ID,
+		Description: fmt.Sprintf("Misión %s (#%d) pasó de %s a %s", m.Title, m.ID, from, m.Status),
+	}); err != nil {
+		return err
+	}
+	if s.WsHub != nil {
+		event := "mission:updated"
+		switch m.Status {
+		case missionStatusCompleted:
+			event = "mission:completed"
+		case missionStatusCancelled:
+			event = "mission:cancelled"
+		}
+		_ = s.notify(event, m.ToResponseDto())
+	}
+	return nil
+}
+
+// recordMissionDeletion audita el borrado y avisa a los clientes con el
+// estado que tenía la misión.
+func (s *Server) recordMissionDeletion(actor auditActor, m *models.Mission) error {
+	if err := s.saveAudit(actor, &models.Audit{
+		Action:      "MISSION_DELETED",
+		Entity:      auditEntityMission,
+		EntityID:    m.ID,
+		Description: fmt.Sprintf("Misión %s (#%d) eliminada en estado %s", m.Title, m.ID, normalizeMissionStatus(m.Status)),
+	}); err != nil {
+		return err
+	}
+	if s.WsHub != nil {
+		_ = s.notify("mission:deleted", m.ToResponseDto())
+	}
+	return nil
+}
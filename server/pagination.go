@@ -0,0 +1,135 @@
+package server
+
+import (
+	"backend-avanzada/repository"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// pageRequest guarda la página pedida para armar los headers de respuesta.
+type pageRequest struct {
+	// Paged es false si el cliente no mandó page, page_size ni cursor: entonces
+	// se devuelve el listado completo, como antes de paginar
+	Paged    bool
+	Page     int
+	PageSize int
+	Cursor   bool
+	// ByID indica que el orden principal es por id, requisito para usar cursor
+	ByID bool
+}
+
+// parseListQuery interpreta ?page, ?page_size, ?sort y ?cursor de los listados.
+// Sin ninguno de los parámetros de página no hay límite; con alguno, la página
+// es de defaultPageSize salvo que page_size diga otra cosa. sortable mapea el nombre público del campo a su columna; sort acepta varios
+// campos separados por coma y "-" delante para orden descendente.
+func parseListQuery(q url.Values, sortable map[string]string, defaultSort ...repository.SortField) (repository.QuerySpec, pageRequest, error) {
+	spec := repository.QuerySpec{Sort: defaultSort}
+	req := pageRequest{Page: 1}
+	if q.Has("page") || q.Has("page_size") || q.Has("cursor") {
+		req.Paged = true
+		req.PageSize = defaultPageSize
+	}
+
+	if v := strings.TrimSpace(q.Get("page_size")); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			return spec, req, fmt.Errorf("invalid page_size %q", v)
+		}
+		if size > maxPageSize {
+			size = maxPageSize
+		}
+		req.PageSize = size
+	}
+	if v := strings.TrimSpace(q.Get("page")); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page <= 0 {
+			return spec, req, fmt.Errorf("invalid page %q", v)
+		}
+		req.Page = page
+	}
+	if v := strings.TrimSpace(q.Get("sort")); v != "" {
+		spec.Sort = nil
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			desc := strings.HasPrefix(field, "-")
+			column, ok := sortable[strings.TrimPrefix(field, "-")]
+			if !ok {
+				return spec, req, fmt.Errorf("invalid sort field %q", field)
+			}
+			spec.Sort = append(spec.Sort, repository.SortField{Column: column, Desc: desc})
+		}
+	}
+	req.ByID = len(spec.Sort) == 0 || spec.Sort[0].Column == "id"
+	if v := strings.TrimSpace(q.Get("cursor")); v != "" {
+		if !req.ByID {
+			return spec, req, fmt.Errorf("cursor requires sorting by id")
+		}
+		id, err := decodeCursor(v)
+		if err != nil {
+			return spec, req, fmt.Errorf("invalid cursor")
+		}
+		spec.AfterID = id
+		req.Cursor = true
+	}
+
+	if !req.Paged {
+		return spec, req, nil
+	}
+	spec.Limit = req.PageSize
+	if !req.Cursor {
+		spec.Offset = (req.Page - 1) * req.PageSize
+	}
+	return spec, req, nil
+}
+
+// writePageHeaders publica la metadata de paginación; el cuerpo sigue siendo
+// el arreglo de elementos. lastID es el id del último elemento de la página.
+func writePageHeaders(w http.ResponseWriter, req pageRequest, total int64, hasMore bool, lastID uint) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if !req.Paged {
+		return
+	}
+	w.Header().Set("X-Page-Size", strconv.Itoa(req.PageSize))
+	if !req.Cursor {
+		pages := (total + int64(req.PageSize) - 1) / int64(req.PageSize)
+		w.Header().Set("X-Page", strconv.Itoa(req.Page))
+		w.Header().Set("X-Total-Pages", strconv.FormatInt(pages, 10))
+	}
+	if req.ByID && hasMore && lastID > 0 {
+		w.Header().Set("X-Next-Cursor", encodeCursor(lastID))
+	}
+}
+
+func filterIfPresent(q url.Values, param, column string) []repository.Filter {
+	v := strings.TrimSpace(q.Get(param))
+	if v == "" {
+		return nil
+	}
+	return []repository.Filter{{Column: column, Op: repository.OpEq, Value: v}}
+}
+
+// El cursor es opaco para el cliente: el id del último registro en base64.
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeCursor(cursor string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
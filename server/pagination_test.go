@@ -0,0 +1,155 @@
+package server
+
+import (
+	"backend-avanzada/repository"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSortFields = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"created_at": "created_at",
+}
+
+func TestParseListQuery(t *testing.T) {
+	cursor := encodeCursor(42)
+	cases := []struct {
+		name        string
+		query       string
+		defaultSort []repository.SortField
+		wantSpec    repository.QuerySpec
+		wantReq     pageRequest
+		wantErr     string
+	}{
+		{
+			name:     "sin parámetros de página trae todo",
+			wantSpec: repository.QuerySpec{},
+			wantReq:  pageRequest{Page: 1, ByID: true},
+		},
+		{
+			name:     "solo page usa el tamaño por defecto",
+			query:    "page=2",
+			wantSpec: repository.QuerySpec{Limit: defaultPageSize, Offset: defaultPageSize},
+			wantReq:  pageRequest{Paged: true, Page: 2, PageSize: defaultPageSize, ByID: true},
+		},
+		{
+			name:     "offset según la página",
+			query:    "page=3&page_size=20",
+			wantSpec: repository.QuerySpec{Limit: 20, Offset: 40},
+			wantReq:  pageRequest{Paged: true, Page: 3, PageSize: 20, ByID: true},
+		},
+		{
+			name:     "page_size se limita a maxPageSize",
+			query:    "page_size=5000",
+			wantSpec: repository.QuerySpec{Limit: maxPageSize},
+			wantReq:  pageRequest{Paged: true, Page: 1, PageSize: maxPageSize, ByID: true},
+		},
+		{
+			name:  "sort con varios campos y descendente",
+			query: "sort=-name,id",
+			wantSpec: repository.QuerySpec{
+				Sort: []repository.SortField{{Column: "name", Desc: true}, {Column: "id"}},
+			},
+			wantReq: pageRequest{Page: 1},
+		},
+		{
+			name:        "sort por defecto del handler",
+			query:       "page_size=10",
+			defaultSort: []repository.SortField{{Column: "id", Desc: true}},
+			wantSpec: repository.QuerySpec{
+				Sort:  []repository.SortField{{Column: "id", Desc: true}},
+				Limit: 10,
+			},
+			wantReq: pageRequest{Paged: true, Page: 1, PageSize: 10, ByID: true},
+		},
+		{
+			name:     "cursor ignora page y no usa offset",
+			query:    "cursor=" + cursor + "&page=4&page_size=10",
+			wantSpec: repository.QuerySpec{Limit: 10, AfterID: 42},
+			wantReq:  pageRequest{Paged: true, Page: 4, PageSize: 10, Cursor: true, ByID: true},
+		},
+		{name: "campo de sort fuera de la lista blanca", query: "sort=password_hash", wantErr: "invalid sort field"},
+		{name: "sort descendente fuera de la lista blanca", query: "sort=-secret", wantErr: "invalid sort field"},
+		{name: "page_size no numérico", query: "page_size=abc", wantErr: "invalid page_size"},
+		{name: "page_size cero", query: "page_size=0", wantErr: "invalid page_size"},
+		{name: "page negativa", query: "page=-1", wantErr: "invalid page"},
+		{name: "cursor con otro orden", query: "sort=name&cursor=" + cursor, wantErr: "cursor requires sorting by id"},
+		{name: "cursor inválido", query: "cursor=no-es-base64!", wantErr: "invalid cursor"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec, req, err := parseListQuery(q, testSortFields, tc.defaultSort...)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !reflect.DeepEqual(spec, tc.wantSpec) {
+				t.Errorf("spec = %+v, want %+v", spec, tc.wantSpec)
+			}
+			if req != tc.wantReq {
+				t.Errorf("pageRequest = %+v, want %+v", req, tc.wantReq)
+			}
+		})
+	}
+}
+
+func TestWritePageHeaders(t *testing.T) {
+	cases := []struct {
+		name    string
+		req     pageRequest
+		total   int64
+		hasMore bool
+		want    map[string]string
+	}{
+		{
+			name:  "sin paginar solo informa el total",
+			req:   pageRequest{Page: 1, ByID: true},
+			total: 25,
+			want:  map[string]string{"X-Total-Count": "25", "X-Page-Size": "", "X-Page": "", "X-Total-Pages": "", "X-Next-Cursor": ""},
+		},
+		{
+			name:    "offset con más páginas",
+			req:     pageRequest{Paged: true, Page: 2, PageSize: 10, ByID: true},
+			total:   25,
+			hasMore: true,
+			want:    map[string]string{"X-Total-Count": "25", "X-Page": "2", "X-Total-Pages": "3", "X-Next-Cursor": encodeCursor(7)},
+		},
+		{
+			name:  "cursor sin más resultados",
+			req:   pageRequest{Paged: true, Page: 1, PageSize: 10, Cursor: true, ByID: true},
+			total: 25,
+			want:  map[string]string{"X-Total-Count": "25", "X-Page": "", "X-Total-Pages": "", "X-Next-Cursor": ""},
+		},
+		{
+			name:    "sin cursor si no se ordena por id",
+			req:     pageRequest{Paged: true, Page: 1, PageSize: 10},
+			total:   25,
+			hasMore: true,
+			want:    map[string]string{"X-Total-Pages": "3", "X-Next-Cursor": ""},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writePageHeaders(w, tc.req, tc.total, tc.hasMore, 7)
+			for header, want := range tc.want {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
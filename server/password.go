@@ -0,0 +1,197 @@
+package server
+
+import (
+	"backend-avanzada/config"
+	"backend-avanzada/models"
+	"backend-avanzada/notifier"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	// bcrypt ignora lo que pase de 72 bytes
+	maxPasswordLength          = 72
+	defaultPasswordResetTTL    = 30 * time.Minute
+	auditActionPasswordChanged = "PASSWORD_CHANGED"
+	auditActionPasswordReset   = "PASSWORD_RESET"
+	auditActionResetRequested  = "PASSWORD_RESET_REQUESTED"
+)
+
+var (
+	errWeakPassword         = errors.New("password does not meet the policy")
+	errWrongCurrentPassword = errors.New("current password is incorrect")
+	errPasswordUnchanged    = errors.New("new password must differ from the current one")
+	errInvalidResetToken    = errors.New("invalid or expired reset token")
+)
+
+func (s *Server) passwordPolicy() config.PasswordPolicy {
+	var policy config.PasswordPolicy
+	if s.Config() != nil {
+		policy = s.Config().PasswordPolicy
+	}
+	if policy.MinLength <= 0 {
+		policy.MinLength = defaultPasswordMinLength
+	}
+	return policy
+}
+
+// validatePassword devuelve errWeakPassword con la lista de reglas incumplidas.
+func (s *Server) validatePassword(password, email string) error {
+	policy := s.passwordPolicy()
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	var problems []string
+	if n := len([]rune(password)); n < policy.MinLength {
+		problems = append(problems, fmt.Sprintf("at least %d characters", policy.MinLength))
+	}
+	if len(password) > maxPasswordLength {
+		problems = append(problems, fmt.Sprintf("at most %d bytes", maxPasswordLength))
+	}
+	if policy.RequireUpper && !hasUpper {
+		problems = append(problems, "an uppercase letter")
+	}
+	if policy.RequireLower && !hasLower {
+		problems = append(problems, "a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		problems = append(problems, "a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		problems = append(problems, "a symbol")
+	}
+	if email != "" && strings.EqualFold(password, strings.TrimSpace(email)) {
+		problems = append(problems, "must not be the email")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errWeakPassword, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// changePassword valida y guarda la nueva contraseña y cierra todas las sesiones.
+func (s *Server) changePassword(user *models.User, password string) error {
+	if err := s.validatePassword(password, user.Email); err != nil {
+		return err
+	}
+	if checkPasswordHash(user.PasswordHash, password) == nil {
+		return errPasswordUnchanged
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.UserRepository.UpdateFields(user.ID, map[string]interface{}{"password_hash": hash}); err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	return s.TokenRepository.RevokeUser(user.ID, time.Now())
+}
+
+func (s *Server) passwordResetTTL() time.Duration {
+	if s.Config() != nil && s.Config().PasswordResetTTLMinutes > 0 {
+		return time.Duration(s.Config().PasswordResetTTLMinutes) * time.Minute
+	}
+	return defaultPasswordResetTTL
+}
+
+// requestPasswordReset genera el token y lo envía por el notifier. Si el email
+// no existe o la cuenta está deshabilitada no hace nada, para no revelarlo.
+func (s *Server) requestPasswordReset(ctx context.Context, email string, actor auditActor) error {
+	user, err := s.UserRepository.FindByEmail(strings.TrimSpace(email))
+	if err != nil || user == nil || user.Disabled {
+		return err
+	}
+	token, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return err
+	}
+	ttl := s.passwordResetTTL()
+	if _, err := s.TokenRepository.SavePasswordReset(&models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashRefreshToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Recibimos una solicitud para restablecer tu contraseña.\nCódigo: %s\nVence en %d minutos.", token, int(ttl.Minutes()))
+	if s.Config() != nil && s.Config().PasswordResetURL != "" {
+		if link, err := url.Parse(s.Config().PasswordResetURL); err == nil {
+			q := link.Query()
+			q.Set("token", token)
+			link.RawQuery = q.Encode()
+			body += "\nEnlace: " + link.String()
+		}
+	}
+	if err := s.notifier.Send(ctx, notifier.Message{
+		To:      user.Email,
+		Subject: "Restablecer contraseña",
+		Body:    body,
+	}); err != nil {
+		return err
+	}
+	actor.UserID = &user.ID
+	s.auditUser(actor, auditActionResetRequested, user.ID, fmt.Sprintf("Solicitud de recuperación de contraseña para %s", user.Email))
+	return nil
+}
+
+// resetPassword canjea el token de recuperación por una contraseña nueva.
+func (s *Server) resetPassword(token, password string, actor auditActor) error {
+	stored, err := s.TokenRepository.FindPasswordResetByHash(hashRefreshToken(token))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.UsedAt != nil || !stored.ExpiresAt.After(time.Now()) {
+		return errInvalidResetToken
+	}
+	user, err := s.UserRepository.FindById(stored.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Disabled {
+		return errInvalidResetToken
+	}
+	// se valida antes de consumir para que un error de política no queme el token
+	if err := s.validatePassword(password, user.Email); err != nil {
+		return err
+	}
+	consumed, err := s.TokenRepository.ConsumePasswordReset(stored, time.Now())
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return errInvalidResetToken
+	}
+	// quien recupera la contraseña por email también levanta el bloqueo de login
+	if err := s.clearLoginFailures(user); err != nil {
+		return err
+	}
+	err = s.changePassword(user, password)
+	if errors.Is(err, errPasswordUnchanged) {
+		// misma contraseña: igual se cierran las sesiones abiertas
+		err = s.TokenRepository.RevokeUser(user.ID, time.Now())
+	}
+	if err != nil {
+		return err
+	}
+	actor.UserID, actor.Email, actor.Role = &user.ID, user.Email, user.Role
+	s.auditUser(actor, auditActionPasswordReset, user.ID, fmt.Sprintf("Contraseña de %s restablecida con token de recuperación", user.Email))
+	return nil
+}
@@ -8,29 +8,68 @@ import (
 
 func (s *Server) router() http.Handler {
 	router := mux.NewRouter()
-	router.Use(s.logger.RequestLogger)
+	router.Use(s.ClientIPMiddleware)
 
 	//  Rutas públicas de autenticación (JWT)
 	router.HandleFunc("/auth/register", s.HandleRegister).Methods(http.MethodPost)
 	router.HandleFunc("/auth/login", s.HandleLogin).Methods(http.MethodPost)
+	router.HandleFunc("/auth/login/2fa", s.HandleTwoFactorLogin).Methods(http.MethodPost)
+	router.HandleFunc("/auth/refresh", s.HandleRefresh).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password/forgot", s.HandleForgotPassword).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password/reset", s.HandleResetPassword).Methods(http.MethodPost)
+
+	// /ws valida el token por su cuenta: puede llegar en el primer mensaje
+	router.HandleFunc("/ws", s.HandleWS).Methods(http.MethodGet)
+
+	// sondas de docker/orquestador, sin token
+	router.HandleFunc("/healthz", s.HandleHealthz).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", s.HandleReadyz).Methods(http.MethodGet)
+
+	// para Prometheus: exige metrics.token (sin él, ver metrics.address)
+	router.HandleFunc("/metrics", s.HandleMetrics).Methods(http.MethodGet)
+
+	// Todo lo demás exige token; routePolicies agrega las restricciones por rol
+	protected := router.NewRoute().Subrouter()
+	protected.Use(s.AuthMiddleware, s.RoutePolicy)
+
+	protected.HandleFunc("/auth/logout", s.HandleLogout).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/password", s.HandleChangePassword).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/setup", s.HandleTwoFactorSetup).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/verify", s.HandleTwoFactorVerify).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/disable", s.HandleTwoFactorDisable).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/recovery-codes", s.HandleRecoveryCodes).Methods(http.MethodPost)
 
 	// RUTAS (Amestris)
-	router.HandleFunc("/alchemists", s.HandleAlchemists).Methods(http.MethodGet, http.MethodPost)
-	router.HandleFunc("/alchemists/{id}", s.HandleAlchemistsWithId).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	protected.HandleFunc("/alchemists", s.HandleAlchemists).Methods(http.MethodGet, http.MethodPost)
+	protected.HandleFunc("/alchemists/{id}", s.HandleAlchemistsWithId).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
 
-	router.HandleFunc("/materials", s.HandleMaterials).Methods(http.MethodGet, http.MethodPost)
-	router.HandleFunc("/materials/{id}", s.HandleMaterialsWithId).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	protected.HandleFunc("/materials", s.HandleMaterials).Methods(http.MethodGet, http.MethodPost)
+	protected.HandleFunc("/materials/{id}", s.HandleMaterialsWithId).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
 
-	router.HandleFunc("/missions", s.HandleMissions).Methods(http.MethodGet, http.MethodPost)
-	router.HandleFunc("/missions/{id}", s.HandleMissionsWithId).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	protected.HandleFunc("/missions", s.HandleMissions).Methods(http.MethodGet, http.MethodPost)
+	protected.HandleFunc("/missions/{id}/status", s.HandleMissionStatus).Methods(http.MethodPatch)
+	protected.HandleFunc("/missions/{id}", s.HandleMissionsWithId).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
 
-	router.HandleFunc("/transmutations", s.HandleTransmutations).Methods(http.MethodGet, http.MethodPost)
-	router.HandleFunc("/transmutations/simulate", s.HandleTransmutationSimulation).Methods(http.MethodPost)
-	router.HandleFunc("/transmutations/{id}", s.HandleTransmutationsWithId).Methods(http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete)
+	protected.HandleFunc("/transmutations", s.HandleTransmutations).Methods(http.MethodGet, http.MethodPost)
+	protected.HandleFunc("/transmutations/simulate", s.HandleTransmutationSimulation).Methods(http.MethodPost)
+	protected.HandleFunc("/transmutations/{id}", s.HandleTransmutationsWithId).Methods(http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete)
 
-	router.HandleFunc("/audits", s.HandleAudits).Methods(http.MethodGet)
+	protected.HandleFunc("/audits", s.HandleAudits).Methods(http.MethodGet)
 
-	router.HandleFunc("/ws", s.HandleWS).Methods(http.MethodGet)
+	protected.HandleFunc("/users", s.HandleUsers).Methods(http.MethodGet, http.MethodPost)
+	protected.HandleFunc("/users/invitations", s.HandleInvitations).Methods(http.MethodPost)
+	protected.HandleFunc("/users/{id}", s.HandleUsersWithId).Methods(http.MethodGet, http.MethodPatch, http.MethodDelete)
+	protected.HandleFunc("/users/{id}/unlock", s.HandleUnlockUser).Methods(http.MethodPost)
+	protected.HandleFunc("/users/{id}/2fa", s.HandleResetUserTwoFactor).Methods(http.MethodDelete)
+	protected.HandleFunc("/events", s.HandleEvents).Methods(http.MethodGet)
+
+	protected.HandleFunc("/admin/config/reload", s.HandleConfigReload).Methods(http.MethodPost)
+
+	protected.HandleFunc("/webhooks", s.HandleWebhooks).Methods(http.MethodGet, http.MethodPost)
+	protected.HandleFunc("/webhooks/{id}", s.HandleWebhooksWithId).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	protected.HandleFunc("/webhooks/{id}/test", s.HandleWebhookTest).Methods(http.MethodPost)
+	protected.HandleFunc("/webhooks/{id}/deliveries", s.HandleWebhookDeliveries).Methods(http.MethodGet)
 
-	return router
+	// fuera del mux para registrar también 404/405
+	return s.logger.RequestLogger(s.instrument(router))
 }
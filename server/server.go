@@ -4,12 +4,14 @@ import (
 	"backend-avanzada/config"
 	"backend-avanzada/logger"
 	"backend-avanzada/models"
+	"backend-avanzada/notifier"
 	"backend-avanzada/repository"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -20,145 +22,203 @@ import (
 
 type Server struct {
 	DB      *gorm.DB
-	Config  *config.Config
 	Handler http.Handler
 
+	// configuración vigente (ver Config); reloadConfig la reemplaza entera
+	cfg        atomic.Pointer[config.Config]
+	configPath string
+	reloadMu   sync.Mutex
+	// avisa al bucle de verificaciones diarias que recalcule la próxima hora
+	dailyReschedule chan struct{}
+	dailyRunning    atomic.Bool
+
 	// Repositorios del proyecto Amestris
-	AlchemistRepository     *repository.AlchemistRepository
-	MaterialRepository      *repository.MaterialRepository
-	MissionRepository       *repository.MissionRepository
-	TransmutationRepository *repository.TransmutationRepository
-	AuditRepository         *repository.AuditRepository
-	UserRepository          *repository.UserRepository
+	AlchemistRepository        *repository.AlchemistRepository
+	MaterialRepository         *repository.MaterialRepository
+	MissionRepository          *repository.MissionRepository
+	TransmutationRepository    *repository.TransmutationRepository
+	TransmutationJobRepository *repository.TransmutationJobRepository
+	AuditRepository            *repository.AuditRepository
+	UserRepository             *repository.UserRepository
+	TokenRepository            *repository.TokenRepository
+	InvitationRepository       *repository.InvitationRepository
+	WebhookRepository          *repository.WebhookRepository
+	WebhookDeliveryRepository  *repository.WebhookDeliveryRepository
+	TwoFactorRepository        *repository.TwoFactorRepository
 
 	// Hub de WebSocket para notificaciones en tiempo real
 	WsHub *Hub
 
 	logger    *logger.Logger
 	taskQueue *TaskQueue
+	notifier  notifier.Notifier
+	metrics   *serverMetrics
+	// un mutex por alquimista para el límite de transmutaciones (ver lockAlchemist)
+	alchemistLocks sync.Map
+	// fallos de login por email e IP (ver login_protection.go)
+	loginThrottle *loginThrottle
+
+	// despacho de webhooks (ver startWebhooks)
+	webhookEvents chan *hubEvent
+	webhookJobs   chan *webhookJob
+	webhookClient *http.Client
+
+	// semilla del resultado de las transmutaciones (ver rollTransmutationOutcome)
+	outcomeSeed int64
 }
 
 const (
 	defaultDailyCheckHour            = "02:00"
 	defaultMaterialLowStockThreshold = 10.0
 	defaultMissionStaleDays          = 7
+	defaultMaxOpenMissions           = 5
 	auditActionDailyMaterialAlert    = "DAILY_MATERIAL_ALERT"
 	auditActionDailyMissionAlert     = "DAILY_MISSION_ALERT"
+	eventDailyMaterialAlert          = "alert:material_low_stock"
+	eventDailyMissionAlert           = "alert:mission_stale"
 	auditEntityMaterial              = "material"
 	auditEntityMission               = "mission"
 )
 
-func NewServer() *Server {
+// NewServer carga la configuración de configPath (vacío usa
+// config.DefaultPath) con los overrides del entorno y termina el proceso si
+// algún campo es inválido.
+func NewServer(configPath string) *Server {
 	s := &Server{
-		logger:    logger.NewLogger(),
-		taskQueue: NewTaskQueue(),
+		logger:        logger.NewLogger(),
+		loginThrottle: newLoginThrottle(),
+		configPath:    configPath,
+
+		dailyReschedule: make(chan struct{}, 1),
 	}
 
-	var cfg config.Config
-	configFile, err := os.ReadFile("config/config.json")
+	cfg, err := config.Load(configPath)
 	if err != nil {
 		s.logger.Fatal(err)
 	}
-	if err := json.Unmarshal(configFile, &cfg); err != nil {
+	s.cfg.Store(cfg)
+	s.logger = logger.New(cfg.Log.Format, cfg.Log.Level, os.Stderr)
+	s.taskQueue = NewTaskQueue(s.logger)
+	s.metrics = newServerMetrics(s)
+	if s.notifier, err = notifier.New(cfg.Notifier, s.logger); err != nil {
 		s.logger.Fatal(err)
 	}
-	s.Config = &cfg
+	s.outcomeSeed = cfg.TransmutationOutcomeSeed
+	if s.outcomeSeed == 0 {
+		s.outcomeSeed = time.Now().UnixNano()
+	}
 	return s
 }
 
 func (s *Server) StartServer() {
-	fmt.Println("🔧 Inicializando base de datos...")
+	s.logger.Info("inicializando base de datos", "database", s.Config().Database)
 	s.initDB()
-
-	s.startDailyVerifications()
+	s.bootstrapSupervisor()
 
 	s.WsHub = NewHub()
+	s.startWebhooks()
 	go s.WsHub.Run()
 
-	fmt.Println("🌐 Configurando CORS...")
+	s.startDailyVerifications()
+	s.watchReloadSignal()
+	s.startMetricsListener()
+
+	if err := s.recoverTransmutationJobs(); err != nil {
+		s.logger.Error("no se pudieron recuperar las transmutaciones en curso", "error", err)
+	}
+
 	corsObj := handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"}),
+		handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "Last-Event-ID", logger.RequestIDHeader}),
+		handlers.ExposedHeaders([]string{"X-Total-Count", "X-Total-Pages", "X-Page", "X-Page-Size", "X-Next-Cursor", logger.RequestIDHeader}),
 	)
 
-	fmt.Println("🧩 Inicializando rutas (mux)...")
 	srv := &http.Server{
-		Addr:    s.Config.Address,
+		Addr:    s.Config().Address,
 		Handler: corsObj(s.router()),
 	}
 
-	fmt.Println("🚀 Servidor escuchando en el puerto", s.Config.Address)
+	s.logger.Info("servidor escuchando", "address", s.Config().Address)
 	if err := srv.ListenAndServe(); err != nil {
 		s.logger.Fatal(err)
 	}
 }
 
+// migratedModels son las tablas que crea AutoMigrate; /readyz verifica que
+// existan con todas sus columnas.
+var migratedModels = []interface{}{
+	&models.Alchemist{},
+	&models.Material{},
+	&models.Mission{},
+	&models.Transmutation{},
+	&models.TransmutationMaterial{},
+	&models.TransmutationJob{},
+	&models.Audit{},
+	&models.User{},
+	&models.RefreshToken{},
+	&models.RevokedToken{},
+	&models.Invitation{},
+	&models.PasswordResetToken{},
+	&models.Webhook{},
+	&models.WebhookDelivery{},
+	&models.RecoveryCode{},
+	&models.LoginChallenge{},
+}
+
 func (s *Server) initDB() {
-	switch s.Config.Database {
+	switch s.Config().Database {
 	case "sqlite":
-		db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+		db, err := gorm.Open(sqlite.Open(s.Config().SQLitePath), &gorm.Config{})
 		if err != nil {
 			s.logger.Fatal(err)
 		}
 		s.DB = db
 
 	case "postgres":
-		// Usa variables del .env seteadas en docker-compose
-		host := os.Getenv("POSTGRES_HOST")
-		user := os.Getenv("POSTGRES_USER")
-		pass := os.Getenv("POSTGRES_PASSWORD")
-		dbn := os.Getenv("POSTGRES_DB")
-		port := os.Getenv("POSTGRES_PORT")
-		if port == "" {
-			port = "5432"
-		}
-
-		dsn := fmt.Sprintf(
-			"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
-			host, user, pass, dbn, port,
-		)
-		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		// database_url, DATABASE_URL o las POSTGRES_* del .env (ver config.Load)
+		db, err := gorm.Open(postgres.Open(s.Config().DatabaseURL), &gorm.Config{})
 		if err != nil {
 			s.logger.Fatal(err)
 		}
 		s.DB = db
 
 	default:
-		s.logger.Fatal(fmt.Errorf("⚠️ tipo de base de datos desconocido: %s", s.Config.Database))
+		s.logger.Fatal(fmt.Errorf("tipo de base de datos desconocido: %s", s.Config().Database))
+	}
+	if err := s.registerDBMetrics(s.DB); err != nil {
+		s.logger.Fatal(err)
 	}
 
-	fmt.Println("📦 Aplicando migraciones...")
-	if err := s.DB.AutoMigrate(
-		&models.Alchemist{},
-		&models.Material{},
-		&models.Mission{},
-		&models.Transmutation{},
-		&models.Audit{},
-		&models.User{},
-	); err != nil {
+	if err := s.DB.AutoMigrate(migratedModels...); err != nil {
 		s.logger.Fatal(err)
 	}
 
-	fmt.Println("🔗 Inicializando repositorios...")
 	s.AlchemistRepository = repository.NewAlchemistRepository(s.DB)
 	s.MaterialRepository = repository.NewMaterialRepository(s.DB)
 	s.MissionRepository = repository.NewMissionRepository(s.DB)
 	s.TransmutationRepository = repository.NewTransmutationRepository(s.DB)
+	s.TransmutationJobRepository = repository.NewTransmutationJobRepository(s.DB)
 	s.AuditRepository = repository.NewAuditRepository(s.DB)
 	s.UserRepository = repository.NewUserRepository(s.DB)
+	s.TokenRepository = repository.NewTokenRepository(s.DB)
+	s.InvitationRepository = repository.NewInvitationRepository(s.DB)
+	s.WebhookRepository = repository.NewWebhookRepository(s.DB)
+	s.WebhookDeliveryRepository = repository.NewWebhookDeliveryRepository(s.DB)
+	s.TwoFactorRepository = repository.NewTwoFactorRepository(s.DB)
 
-	fmt.Println("✅ Base de datos y repositorios inicializados correctamente.")
+	s.logger.Info("base de datos lista")
 }
 
 func (s *Server) startDailyVerifications() {
 	if s.MaterialRepository == nil || s.MissionRepository == nil || s.AuditRepository == nil {
 		return
 	}
+	s.dailyRunning.Store(true)
 	go func() {
-		s.logger.Printf("⏰ Iniciando rutina de verificaciones diarias")
+		defer s.dailyRunning.Store(false)
 		if err := s.runDailyChecks(); err != nil {
-			s.logger.Printf("⚠️ Error en verificación diaria inicial: %v", err)
+			s.logger.Error("falló la verificación diaria inicial", "error", err)
 		}
 		for {
 			next := s.nextDailyCheck(time.Now())
@@ -166,9 +226,16 @@ func (s *Server) startDailyVerifications() {
 			if wait <= 0 {
 				wait = 24 * time.Hour
 			}
-			time.Sleep(wait)
-			if err := s.runDailyChecks(); err != nil {
-				s.logger.Printf("⚠️ Error en verificación diaria: %v", err)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				if err := s.runDailyChecks(); err != nil {
+					s.logger.Error("falló la verificación diaria", "error", err)
+				}
+			case <-s.dailyReschedule:
+				// cambió daily_check_hour: se recalcula sin ejecutar
+				timer.Stop()
+				s.logger.Info("verificación diaria reprogramada", "next", s.nextDailyCheck(time.Now()))
 			}
 		}
 	}()
@@ -176,12 +243,12 @@ func (s *Server) startDailyVerifications() {
 
 func (s *Server) nextDailyCheck(now time.Time) time.Time {
 	hour := defaultDailyCheckHour
-	if s.Config != nil && s.Config.DailyCheckHour != "" {
-		hour = s.Config.DailyCheckHour
+	if s.Config() != nil && s.Config().DailyCheckHour != "" {
+		hour = s.Config().DailyCheckHour
 	}
 	parsed, err := time.Parse("15:04", hour)
 	if err != nil {
-		s.logger.Printf("⚠️ No se pudo interpretar daily_check_hour (%s): %v", hour, err)
+		s.logger.Warn("daily_check_hour inválido, se usa el valor por defecto", "value", hour, "error", err)
 		parsed, _ = time.Parse("15:04", defaultDailyCheckHour)
 	}
 	target := time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(), 0, 0, now.Location())
@@ -192,38 +259,49 @@ func (s *Server) nextDailyCheck(now time.Time) time.Time {
 }
 
 func (s *Server) runDailyChecks() error {
+	start := time.Now()
 	var errs []error
-	if err := s.checkMaterialUsage(); err != nil {
+	if err := s.observeDailyCheck("material_usage", s.checkMaterialUsage); err != nil {
 		errs = append(errs, fmt.Errorf("material usage: %w", err))
 	}
-	if err := s.checkStaleMissions(); err != nil {
+	if err := s.observeDailyCheck("stale_missions", s.checkStaleMissions); err != nil {
 		errs = append(errs, fmt.Errorf("missions: %w", err))
 	}
+	if err := s.observeDailyCheck("purge_tokens", func() error {
+		return s.TokenRepository.PurgeExpired(time.Now())
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("tokens: %w", err))
+	}
+	if err := s.observeDailyCheck("purge_2fa_challenges", func() error {
+		return s.TwoFactorRepository.PurgeExpired(time.Now())
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("2fa challenges: %w", err))
+	}
 	if len(errs) > 0 {
 		return errors.Join(errs...)
 	}
-	s.logger.Printf("✅ Verificaciones diarias completadas")
+	s.logger.Info("verificaciones diarias completadas", "duration_ms", time.Since(start).Milliseconds())
 	return nil
 }
 
 func (s *Server) checkMaterialUsage() error {
 	threshold := defaultMaterialLowStockThreshold
-	if s.Config != nil && s.Config.MaterialLowStockThreshold > 0 {
-		threshold = s.Config.MaterialLowStockThreshold
+	if s.Config() != nil && s.Config().MaterialLowStockThreshold > 0 {
+		threshold = s.Config().MaterialLowStockThreshold
 	}
 	materials, err := s.MaterialRepository.FindLowStock(threshold)
 	if err != nil {
 		return err
 	}
 	if len(materials) == 0 {
-		s.logger.Printf("🔍 Verificación diaria: sin alertas de materiales (umbral %.2f)", threshold)
+		s.logger.Info("verificación diaria: sin alertas de materiales", "threshold", threshold)
 		return nil
 	}
 	var errs []error
 	for _, m := range materials {
 		description := fmt.Sprintf("Material %s (#%d) con stock %.2f por debajo del umbral %.2f", m.Name, m.ID, m.Stock, threshold)
-		s.logger.Printf("⚠️ %s", description)
-		if _, saveErr := s.AuditRepository.Save(&models.Audit{
+		s.logger.Warn(description, "material_id", m.ID)
+		if saveErr := s.saveAudit(systemActor, &models.Audit{
 			Action:      auditActionDailyMaterialAlert,
 			Entity:      auditEntityMaterial,
 			EntityID:    m.ID,
@@ -231,6 +309,11 @@ func (s *Server) checkMaterialUsage() error {
 		}); saveErr != nil {
 			errs = append(errs, saveErr)
 		}
+		_ = s.notify(eventDailyMaterialAlert, map[string]interface{}{
+			"material":    m.ToResponseDto(),
+			"threshold":   threshold,
+			"description": description,
+		})
 	}
 	if len(errs) > 0 {
 		return errors.Join(errs...)
@@ -240,16 +323,16 @@ func (s *Server) checkMaterialUsage() error {
 
 func (s *Server) checkStaleMissions() error {
 	staleDays := defaultMissionStaleDays
-	if s.Config != nil && s.Config.MissionStaleDays > 0 {
-		staleDays = s.Config.MissionStaleDays
+	if s.Config() != nil && s.Config().MissionStaleDays > 0 {
+		staleDays = s.Config().MissionStaleDays
 	}
 	cutoff := time.Now().AddDate(0, 0, -staleDays)
-	missions, err := s.MissionRepository.FindStale(cutoff, []string{"COMPLETED", "CANCELLED"})
+	missions, err := s.MissionRepository.FindStale(cutoff, missionTerminalStatuses())
 	if err != nil {
 		return err
 	}
 	if len(missions) == 0 {
-		s.logger.Printf("🔍 Verificación diaria: sin misiones atrasadas (límite %d días)", staleDays)
+		s.logger.Info("verificación diaria: sin misiones atrasadas", "stale_days", staleDays)
 		return nil
 	}
 	var errs []error
@@ -263,8 +346,8 @@ func (s *Server) checkStaleMissions() error {
 			lastUpdate = mission.CreatedAt
 		}
 		description := fmt.Sprintf("Misión %s (#%d) sin cerrar desde %s (estado %s, asignado a %s)", mission.Title, mission.ID, lastUpdate.Format(time.RFC3339), mission.Status, assigned)
-		s.logger.Printf("⚠️ %s", description)
-		if _, saveErr := s.AuditRepository.Save(&models.Audit{
+		s.logger.Warn(description, "mission_id", mission.ID)
+		if saveErr := s.saveAudit(systemActor, &models.Audit{
 			Action:      auditActionDailyMissionAlert,
 			Entity:      auditEntityMission,
 			EntityID:    mission.ID,
@@ -272,6 +355,11 @@ func (s *Server) checkStaleMissions() error {
 		}); saveErr != nil {
 			errs = append(errs, saveErr)
 		}
+		_ = s.notify(eventDailyMissionAlert, map[string]interface{}{
+			"mission":     mission.ToResponseDto(),
+			"stale_days":  staleDays,
+			"description": description,
+		})
 	}
 	if len(errs) > 0 {
 		return errors.Join(errs...)
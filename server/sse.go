@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+var errStreamingUnsupported = errors.New("streaming unsupported")
+
+// HandleEvents publica por Server-Sent Events los mismos eventos del hub que /ws.
+// Acepta ?topics= (separados por coma, misma sintaxis que subscribe) y retoma
+// desde el header Last-Event-ID o ?last_event_id=, ya que EventSource no puede
+// enviar headers en la primera conexión. Como en /ws, el token se revalida
+// mientras el stream sigue abierto y, si deja de valer, se envía un evento
+// error y se corta.
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, errStreamingUnsupported)
+		return
+	}
+	// AuthMiddleware ya validó el token; aquí solo se guarda para revalidarlo
+	token := bearerToken(r)
+	claims, err := parseToken(token)
+	if err != nil {
+		s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidToken)
+		return
+	}
+	session := newStreamSession(token, claims)
+
+	client := newClient(s.WsHub, nil)
+	client.role = roleFromContext(r.Context())
+	if id, restricted := alchemistScope(r); restricted {
+		client.alchemistID = id
+	}
+	if v := strings.TrimSpace(r.URL.Query().Get("topics")); v != "" {
+		if err := client.setTopics(strings.Split(v, ","), true); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		seq, err := strconv.ParseUint(strings.TrimSpace(lastID), 10, 64)
+		if err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("invalid Last-Event-ID %q", lastID))
+			return
+		}
+		client.resumeFrom = seq
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// evita que proxies como nginx acumulen la respuesta
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	client.registered = true
+	s.WsHub.register <- client
+	defer func() { s.WsHub.unregister <- client }()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	recheck := time.NewTimer(nextStreamCheck(session, time.Now()))
+	defer recheck.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-client.send:
+			if !ok {
+				// el hub lo descartó por lento; el cliente reconecta con Last-Event-ID
+				return
+			}
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-recheck.C:
+			now := time.Now()
+			if err := s.checkStreamSession(session, now); err != nil {
+				_ = writeSSE(w, sessionEndEvent(err))
+				flusher.Flush()
+				return
+			}
+			recheck.Reset(nextStreamCheck(session, now))
+		}
+	}
+}
+
+// writeSSE escribe un evento; data es el mismo sobre JSON que recibe /ws.
+func writeSSE(w http.ResponseWriter, ev *hubEvent) error {
+	if ev.Seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", ev.Seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, ev.Payload)
+	return err
+}
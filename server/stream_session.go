@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// cada cuánto se revalida el token de una conexión abierta (/ws, /events)
+const streamSessionRecheck = 30 * time.Second
+
+var (
+	errSessionExpired = errors.New("session expired")
+	errSessionChanged = errors.New("session role or alchemist changed")
+)
+
+// streamSession es el token con el que se abrió una conexión que sigue viva.
+// El token solo se valida al conectar; checkStreamSession lo vuelve a validar
+// para cerrar la conexión si vence, se revoca o el usuario cambia.
+type streamSession struct {
+	token     string
+	expiresAt time.Time
+}
+
+func newStreamSession(token string, claims *jwtClaims) *streamSession {
+	sess := &streamSession{token: token}
+	if claims.ExpiresAt != nil {
+		sess.expiresAt = claims.ExpiresAt.Time
+	}
+	return sess
+}
+
+// checkStreamSession devuelve nil si la sesión sigue valiendo en now: el token
+// no venció ni fue revocado (logout, cambio de contraseña) y el usuario sigue
+// habilitado con el mismo rol y alquimista que lleva el token.
+func (s *Server) checkStreamSession(sess *streamSession, now time.Time) error {
+	if !sess.expiresAt.IsZero() && !now.Before(sess.expiresAt) {
+		return errSessionExpired
+	}
+	claims, user, err := s.authenticateUser(sess.token)
+	if err != nil {
+		return err
+	}
+	if user.Role != claims.Role || !sameAlchemist(user.AlchemistID, claims.AlchemistID) {
+		return errSessionChanged
+	}
+	return nil
+}
+
+// nextStreamCheck es la espera hasta la próxima revalidación: el intervalo
+// fijo o el vencimiento del token, lo que llegue antes.
+func nextStreamCheck(sess *streamSession, now time.Time) time.Duration {
+	wait := streamSessionRecheck
+	if !sess.expiresAt.IsZero() {
+		if untilExp := sess.expiresAt.Sub(now); untilExp < wait {
+			wait = untilExp
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// sessionEndEvent es el aviso (mismo sobre "error" que /ws) que recibe el
+// cliente antes de que se cierre la conexión.
+func sessionEndEvent(err error) *hubEvent {
+	b, _ := json.Marshal(wsEnvelope{Type: "error", Timestamp: time.Now().UTC(), Data: map[string]string{"message": err.Error()}})
+	return &hubEvent{Type: "error", Payload: b}
+}
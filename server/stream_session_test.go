@@ -0,0 +1,112 @@
+package server
+
+import (
+	"backend-avanzada/logger"
+	"backend-avanzada/models"
+	"backend-avanzada/repository"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCheckStreamSession(t *testing.T) {
+	db := openTestDB(t, &models.User{}, &models.RevokedToken{})
+	s := &Server{
+		DB:              db,
+		UserRepository:  repository.NewUserRepository(db),
+		TokenRepository: repository.NewTokenRepository(db),
+		logger:          logger.New("text", "error", io.Discard),
+	}
+	alchemistID := uint(3)
+
+	cases := []struct {
+		name    string
+		change  func(t *testing.T, u *models.User, jti string)
+		after   time.Duration
+		wantErr error
+	}{
+		{name: "sesión vigente"},
+		{name: "token vencido", after: 2 * time.Minute, wantErr: errSessionExpired},
+		{
+			name: "token revocado por logout",
+			change: func(t *testing.T, u *models.User, jti string) {
+				if err := s.TokenRepository.RevokeAccess(jti, time.Now().Add(time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: errTokenRevoked,
+		},
+		{
+			name: "usuario deshabilitado",
+			change: func(t *testing.T, u *models.User, jti string) {
+				if err := s.UserRepository.UpdateFields(u.ID, map[string]interface{}{"disabled": true}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: errAccountDisabled,
+		},
+		{
+			name: "rol cambiado",
+			change: func(t *testing.T, u *models.User, jti string) {
+				if err := s.UserRepository.UpdateFields(u.ID, map[string]interface{}{"role": roleSupervisor}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: errSessionChanged,
+		},
+		{
+			name: "alquimista desvinculado",
+			change: func(t *testing.T, u *models.User, jti string) {
+				if err := s.UserRepository.UpdateFields(u.ID, map[string]interface{}{"alchemist_id": nil}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: errSessionChanged,
+		},
+	}
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// un alquimista por caso: el índice único no admite repetirlo
+			aid := alchemistID + uint(i)
+			u := &models.User{Email: tc.name + "@x.com", Role: roleAlchemist, AlchemistID: &aid}
+			if _, err := s.UserRepository.Save(u); err != nil {
+				t.Fatal(err)
+			}
+			issued, err := generateToken(u.ID, u.Email, u.Role, u.AlchemistID, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := parseToken(issued.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.change != nil {
+				tc.change(t, u, issued.JTI)
+			}
+			err = s.checkStreamSession(newStreamSession(issued.Token, claims), time.Now().Add(tc.after))
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("err = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNextStreamCheck(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name    string
+		expires time.Time
+		want    time.Duration
+	}{
+		{"sin vencimiento", time.Time{}, streamSessionRecheck},
+		{"vence después del intervalo", now.Add(time.Hour), streamSessionRecheck},
+		{"vence antes del intervalo", now.Add(5 * time.Second), 5 * time.Second},
+		{"ya vencido", now.Add(-time.Second), 0},
+	}
+	for _, tc := range cases {
+		if got := nextStreamCheck(&streamSession{expiresAt: tc.expires}, now); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -1,58 +1,82 @@
 package server
 
 import (
+	"backend-avanzada/logger"
 	"context"
-	"fmt"
 	"sync"
 	"time"
 )
 
 type TaskQueue struct {
-	mu    sync.Mutex
-	tasks map[int]context.CancelFunc
+	mu     sync.Mutex
+	tasks  map[int]*queuedTask
+	logger *logger.Logger
 }
 
-func NewTaskQueue() *TaskQueue {
+type queuedTask struct {
+	cancel context.CancelFunc
+}
+
+func NewTaskQueue(l *logger.Logger) *TaskQueue {
 	return &TaskQueue{
-		tasks: make(map[int]context.CancelFunc),
+		tasks:  make(map[int]*queuedTask),
+		logger: l,
 	}
 }
 
-func (tq *TaskQueue) StartTask(id int, duration time.Duration, task func() error) {
-	ctx, cancel := context.WithCancel(context.Background())
+// StartTask ejecuta task tras duration. De parent solo se conservan los
+// valores (request_id): la tarea sobrevive al request que la originó.
+func (tq *TaskQueue) StartTask(parent context.Context, id int, duration time.Duration, task func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	entry := &queuedTask{cancel: cancel}
 
 	tq.mu.Lock()
-	tq.tasks[id] = cancel
+	// reprogramar un id cancela la tarea anterior: si no, ambas se ejecutarían
+	if prev, exists := tq.tasks[id]; exists {
+		prev.cancel()
+	}
+	tq.tasks[id] = entry
 	tq.mu.Unlock()
 
 	go func() {
 		defer func() {
 			tq.mu.Lock()
-			delete(tq.tasks, id)
+			// la tarea pudo reprogramarse con el mismo id mientras corría
+			if tq.tasks[id] == entry {
+				delete(tq.tasks, id)
+			}
 			tq.mu.Unlock()
 		}()
 
+		log := tq.logger.FromContext(ctx).With("task_id", id)
 		select {
 		case <-ctx.Done():
-			fmt.Printf("La tarea con ID %d fue cancelada.\n", id)
+			log.Info("tarea cancelada")
 		case <-time.After(duration):
-			fmt.Printf("Iniciando tarea asíncrona con id %d...\n", id)
-			err := task()
-			if err != nil {
-				fmt.Printf("Error en tarea asíncrona: %v\n", err)
+			log.Debug("iniciando tarea asíncrona")
+			if err := task(ctx); err != nil {
+				log.Error("falló la tarea asíncrona", "error", err)
+				return
 			}
-			fmt.Printf("La tarea con ID %d fue completada tras %v.\n", id, duration)
+			log.Info("tarea completada", "waited_ms", duration.Milliseconds())
 		}
 	}()
 }
 
+// Active cuenta las tareas programadas o en ejecución.
+func (tq *TaskQueue) Active() int {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	return len(tq.tasks)
+}
+
 func (tq *TaskQueue) CancelTask(id int) bool {
 	tq.mu.Lock()
-	cancel, exists := tq.tasks[id]
+	entry, exists := tq.tasks[id]
 	tq.mu.Unlock()
 
 	if exists {
-		cancel()
+		entry.cancel()
 		return true
 	}
 	return false
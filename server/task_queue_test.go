@@ -0,0 +1,42 @@
+package server
+
+import (
+	"backend-avanzada/logger"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartTaskReplacesSameID(t *testing.T) {
+	tq := NewTaskQueue(logger.New("text", "error", io.Discard))
+	var first, second atomic.Int32
+	done := make(chan struct{})
+
+	tq.StartTask(context.Background(), 1, 50*time.Millisecond, func(ctx context.Context) error {
+		first.Add(1)
+		return nil
+	})
+	tq.StartTask(context.Background(), 1, 10*time.Millisecond, func(ctx context.Context) error {
+		second.Add(1)
+		close(done)
+		return nil
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("la tarea reprogramada no se ejecutó")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if first.Load() != 0 {
+		t.Errorf("la tarea reemplazada se ejecutó %d veces, want 0", first.Load())
+	}
+	if second.Load() != 1 {
+		t.Errorf("la tarea nueva se ejecutó %d veces, want 1", second.Load())
+	}
+	if n := tq.Active(); n != 0 {
+		t.Errorf("Active() = %d, want 0", n)
+	}
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"backend-avanzada/api"
 	"backend-avanzada/models"
+	"backend-avanzada/repository"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -23,6 +26,7 @@ const (
 	defaultCatalystQuality             = 3
 	minCatalystQuality                 = 1
 	maxCatalystQuality                 = 5
+	defaultTransmutationConcurrency    = 1
 	transmutationStatusPendingApproval = "PENDING_APPROVAL"
 	transmutationStatusInProgress      = "IN_PROGRESS"
 	transmutationStatusCompleted       = "COMPLETED"
@@ -31,19 +35,24 @@ const (
 )
 
 var (
-	errTransmutationInProgress = errors.New("transmutation already running for this alchemist")
-	errAlchemistNotFound       = errors.New("alchemist not found")
-	errInvalidComplexityLevel  = errors.New("invalid complexity level")
-	errInvalidRiskLevel        = errors.New("invalid risk level")
-	errMaterialNotFound        = errors.New("material not found")
-	errInvalidMaterialQuantity = errors.New("material quantity must be positive")
-
-	allowedTransmutationStatuses = map[string]bool{
-		transmutationStatusPendingApproval: true,
-		transmutationStatusInProgress:      true,
-		transmutationStatusCompleted:       true,
-		transmutationStatusFailed:          true,
-		transmutationStatusCancelled:       true,
+	errTransmutationLimitReached      = errors.New("alchemist reached the concurrent transmutation limit")
+	errTransmutationStatusChanged     = errors.New("transmutation status changed concurrently")
+	errIllegalTransmutationTransition = errors.New("illegal transmutation status transition")
+	errAlchemistNotFound              = errors.New("alchemist not found")
+	errInvalidComplexityLevel         = errors.New("invalid complexity level")
+	errInvalidRiskLevel               = errors.New("invalid risk level")
+	errMaterialNotFound               = errors.New("material not found")
+	errInvalidMaterialQuantity        = errors.New("material quantity must be positive")
+
+	// transmutationTransitions son los cambios de estado permitidos; FAILED y
+	// COMPLETED solo salen de una transmutación en curso y los estados sin
+	// salidas son terminales.
+	transmutationTransitions = map[string][]string{
+		transmutationStatusPendingApproval: {transmutationStatusInProgress, transmutationStatusCancelled},
+		transmutationStatusInProgress:      {transmutationStatusCompleted, transmutationStatusFailed, transmutationStatusCancelled},
+		transmutationStatusCompleted:       {},
+		transmutationStatusFailed:          {},
+		transmutationStatusCancelled:       {},
 	}
 
 	complexityWeights = map[string]float64{
@@ -63,6 +72,14 @@ var (
 	}
 )
 
+var transmutationSortFields = map[string]string{
+	"id":             "id",
+	"status":         "status",
+	"alchemist_id":   "alchemist_id",
+	"estimated_cost": "estimated_cost",
+	"created_at":     "created_at",
+}
+
 func (s *Server) HandleTransmutations(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -98,26 +115,40 @@ func (s *Server) HandleTransmutationsWithId(w http.ResponseWriter, r *http.Reque
 }
 
 func (s *Server) handleGetAllTransmutations(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	items, err := s.TransmutationRepository.FindAll()
+	q := r.URL.Query()
+	spec, pageReq, err := parseListQuery(q, transmutationSortFields, repository.SortField{Column: "id", Desc: true})
+	if err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	if status := strings.TrimSpace(q.Get("status")); status != "" {
+		spec.Filters = append(spec.Filters, repository.Filter{Column: "status", Op: repository.OpEq, Value: strings.ToUpper(status)})
+	}
+	if own, restricted := alchemistScope(r); restricted {
+		spec.Filters = append(spec.Filters, repository.Filter{Column: "alchemist_id", Op: repository.OpEq, Value: own})
+	} else {
+		spec.Filters = append(spec.Filters, filterIfPresent(q, "alchemist_id", "alchemist_id")...)
+	}
+	page, err := s.TransmutationRepository.FindPage(spec)
 	if err != nil {
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	result := make([]*api.TransmutationResponseDto, 0, len(items))
-	for _, t := range items {
+	result := make([]*api.TransmutationResponseDto, 0, len(page.Items))
+	var lastID uint
+	for _, t := range page.Items {
 		result = append(result, t.ToResponseDto(true))
+		lastID = t.ID
 	}
 	w.Header().Set("Content-Type", "application/json")
+	writePageHeaders(w, pageReq, page.Total, page.HasMore, lastID)
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	s.logger.Info(http.StatusOK, r.URL.Path, start)
 }
 
 func (s *Server) handleCreateTransmutation(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	var req api.TransmutationRequestDto
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
@@ -127,11 +158,10 @@ func (s *Server) handleCreateTransmutation(w http.ResponseWriter, r *http.Reques
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("alchemist_id is required"))
 		return
 	}
-	s.respondStartTransmutation(w, r, *req.AlchemistID, &req, start)
+	s.respondStartTransmutation(w, r, *req.AlchemistID, &req)
 }
 
 func (s *Server) handleSimulateTransmutationCost(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	var req api.TransmutationSimulationRequestDto
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
@@ -154,11 +184,9 @@ func (s *Server) handleSimulateTransmutationCost(w http.ResponseWriter, r *http.
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	s.logger.Info(http.StatusOK, r.URL.Path, start)
 }
 
 func (s *Server) handleStartTransmutation(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	idStr := strings.TrimSpace(mux.Vars(r)["id"])
 	if idStr == "" {
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("missing alchemist id in path"))
@@ -178,16 +206,20 @@ func (s *Server) handleStartTransmutation(w http.ResponseWriter, r *http.Request
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("body alchemist_id does not match path id"))
 		return
 	}
-	s.respondStartTransmutation(w, r, alchemistID, &req, start)
+	s.respondStartTransmutation(w, r, alchemistID, &req)
 }
 
-func (s *Server) respondStartTransmutation(w http.ResponseWriter, r *http.Request, alchemistID int, req *api.TransmutationRequestDto, start time.Time) {
-	t, err := s.startTransmutation(alchemistID, req)
+func (s *Server) respondStartTransmutation(w http.ResponseWriter, r *http.Request, alchemistID int, req *api.TransmutationRequestDto) {
+	if !canAccessAlchemist(r, uint(alchemistID)) {
+		s.HandleError(w, http.StatusForbidden, r.URL.Path, errForbidden)
+		return
+	}
+	t, err := s.startTransmutation(actorFromRequest(r), alchemistID, req)
 	if err != nil {
 		switch {
 		case errors.Is(err, errAlchemistNotFound):
 			s.HandleError(w, http.StatusNotFound, r.URL.Path, err)
-		case errors.Is(err, errTransmutationInProgress):
+		case errors.Is(err, errTransmutationLimitReached):
 			s.HandleError(w, http.StatusConflict, r.URL.Path, err)
 		case errors.Is(err, errInvalidComplexityLevel), errors.Is(err, errInvalidRiskLevel), errors.Is(err, errInvalidMaterialQuantity):
 			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
@@ -204,10 +236,9 @@ func (s *Server) respondStartTransmutation(w http.ResponseWriter, r *http.Reques
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	s.logger.Info(http.StatusAccepted, r.URL.Path, start)
 }
 
-func (s *Server) startTransmutation(alchemistID int, req *api.TransmutationRequestDto) (*models.Transmutation, error) {
+func (s *Server) startTransmutation(actor auditActor, alchemistID int, req *api.TransmutationRequestDto) (*models.Transmutation, error) {
 	alch, err := s.AlchemistRepository.FindById(alchemistID)
 	if err != nil {
 		return nil, err
@@ -215,14 +246,6 @@ func (s *Server) startTransmutation(alchemistID int, req *api.TransmutationReque
 	if alch == nil {
 		return nil, errAlchemistNotFound
 	}
-	active, err := s.TransmutationRepository.HasActiveForAlchemist(alch.ID, transmutationStatusPendingApproval, transmutationStatusInProgress)
-	if err != nil {
-		return nil, err
-	}
-	if active {
-		return nil, errTransmutationInProgress
-	}
-
 	desc := strings.TrimSpace(req.Description)
 	if desc == "" {
 		desc = "Generic transmutation"
@@ -245,6 +268,16 @@ func (s *Server) startTransmutation(alchemistID int, req *api.TransmutationReque
 		durationSeconds = int(s.transmutationDuration(desc).Seconds())
 	}
 
+	materials := make([]models.TransmutationMaterial, 0, len(simulation.MaterialsBreakdown))
+	for _, item := range simulation.MaterialsBreakdown {
+		materials = append(materials, models.TransmutationMaterial{
+			MaterialID: uint(item.MaterialID),
+			Quantity:   item.Quantity,
+			UnitCost:   item.UnitCost,
+			Subtotal:   item.Subtotal,
+		})
+	}
+
 	t := &models.Transmutation{
 		Description:            desc,
 		Status:                 transmutationStatusPendingApproval,
@@ -252,13 +285,27 @@ func (s *Server) startTransmutation(alchemistID int, req *api.TransmutationReque
 		Alchemist:              alch,
 		EstimatedCost:          simulation.EstimatedCost,
 		EstimatedDurationTotal: durationSeconds,
+		Complexity:             simulation.Complexity,
+		RiskLevel:              simulation.RiskLevel,
+		CatalystQuality:        simulation.CatalystQuality,
+		Materials:              materials,
+	}
+	limit := s.transmutationConcurrencyLimit(alch.Rank)
+	unlock := s.lockAlchemist(alch.ID)
+	active, err := s.TransmutationRepository.CreateWithinLimit(t, int64(limit), transmutationStatusPendingApproval, transmutationStatusInProgress)
+	unlock()
+	if errors.Is(err, repository.ErrActiveLimit) {
+		return nil, fmt.Errorf("%w: %s has %d active (rank %q allows %d)", errTransmutationLimitReached, alch.Name, active, alch.Rank, limit)
 	}
-	saved, err := s.TransmutationRepository.Save(t)
 	if err != nil {
 		return nil, err
 	}
+	saved := t
 	saved.Alchemist = alch
-	if err := s.createTransmutationAudit("TRANSMUTATION_REQUESTED", saved.ID, fmt.Sprintf("Transmutación #%d solicitada por %s", saved.ID, alch.Name)); err != nil {
+	if reloaded, err := s.TransmutationRepository.FindById(int(saved.ID)); err == nil && reloaded != nil {
+		saved = reloaded
+	}
+	if err := s.createTransmutationAudit(actor, "TRANSMUTATION_REQUESTED", saved.ID, fmt.Sprintf("Transmutación #%d solicitada por %s", saved.ID, alch.Name)); err != nil {
 		_ = s.TransmutationRepository.Delete(saved)
 		return nil, err
 	}
@@ -271,43 +318,32 @@ func (s *Server) startTransmutation(alchemistID int, req *api.TransmutationReque
 	return saved, nil
 }
 
-func (s *Server) scheduleTransmutation(t *models.Transmutation) error {
-	alch := t.Alchemist
-	if alch == nil {
-		found, err := s.AlchemistRepository.FindById(int(t.AlchemistID))
-		if err != nil {
-			return err
-		}
-		if found == nil {
-			return fmt.Errorf("alchemist %d not found", t.AlchemistID)
-		}
-		alch = found
-		t.Alchemist = alch
-	}
+// lockAlchemist serializa dentro del proceso las altas de un mismo alquimista;
+// con sqlite es lo único que evita pasar el límite de concurrencia.
+func (s *Server) lockAlchemist(id uint) (unlock func()) {
+	v, _ := s.alchemistLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
 
-	durationSeconds := t.EstimatedDurationTotal
-	if durationSeconds <= 0 {
-		durationSeconds = int(s.transmutationDuration(t.Description).Seconds())
-		t.EstimatedDurationTotal = durationSeconds
+// transmutationConcurrencyLimit devuelve cuántas transmutaciones pendientes o en
+// curso puede tener a la vez un alquimista del rango indicado.
+func (s *Server) transmutationConcurrencyLimit(rank string) int {
+	limit := defaultTransmutationConcurrency
+	if s.Config() == nil {
+		return limit
 	}
-	duration := time.Duration(durationSeconds) * time.Second
-
-	s.taskQueue.StartTask(int(t.AlchemistID), duration, func() error {
-		if err := s.TransmutationRepository.UpdateStatus(t.ID, transmutationStatusCompleted); err != nil {
-			return err
-		}
-		if err := s.createTransmutationAudit("TRANSMUTATION_COMPLETED", t.ID, fmt.Sprintf("Transmutación #%d completada para %s", t.ID, alch.Name)); err != nil {
-		}
-		// notificar completada (cargar DTO actualizado para enviar con alchemist)
-		if s.WsHub != nil {
-			if updated, e := s.TransmutationRepository.FindById(int(t.ID)); e == nil && updated != nil {
-				_ = s.notify("transmutation:completed", updated.ToResponseDto(true))
-			}
+	if s.Config().TransmutationConcurrencyDefault > 0 {
+		limit = s.Config().TransmutationConcurrencyDefault
+	}
+	key := strings.ToUpper(strings.TrimSpace(rank))
+	for configured, value := range s.Config().TransmutationConcurrencyByRank {
+		if strings.ToUpper(strings.TrimSpace(configured)) == key && value > 0 {
+			return value
 		}
-		return nil
-	})
-
-	return nil
+	}
+	return limit
 }
 
 func (s *Server) calculateTransmutationSimulation(req *api.TransmutationSimulationRequestDto) (*api.TransmutationSimulationResponseDto, error) {
@@ -520,8 +556,8 @@ func computeCatalystModifier(quality int) float64 {
 }
 
 func (s *Server) estimateDurationSeconds(description string, complexityWeight, riskMultiplier float64, catalystQuality int, materialCount int) int {
-	base := float64(s.Config.TransmutationDuration)
-	high := float64(s.Config.TransmutationDurationHigh)
+	base := float64(s.Config().TransmutationDuration)
+	high := float64(s.Config().TransmutationDurationHigh)
 	if high < base {
 		high = base
 	}
@@ -574,13 +610,12 @@ func (s *Server) transmutationDuration(description string) time.Duration {
 	catalystQuality := deriveCatalystQuality(nil, desc)
 	seconds := s.estimateDurationSeconds(desc, complexityWeight, riskMultiplier, catalystQuality, 0)
 	if seconds <= 0 {
-		seconds = s.Config.TransmutationDuration
+		seconds = s.Config().TransmutationDuration
 	}
 	return time.Duration(seconds) * time.Second
 }
 
 func (s *Server) handleGetTransmutationByID(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	id, err := strconv.Atoi(strings.TrimSpace(mux.Vars(r)["id"]))
 	if err != nil {
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
@@ -595,15 +630,17 @@ func (s *Server) handleGetTransmutationByID(w http.ResponseWriter, r *http.Reque
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if !canAccessAlchemist(r, t.AlchemistID) {
+		s.HandleError(w, http.StatusForbidden, r.URL.Path, errForbidden)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(t.ToResponseDto(true)); err != nil {
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	s.logger.Info(http.StatusOK, r.URL.Path, start)
 }
 func (s *Server) handleUpdateTransmutationStatus(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	id, err := strconv.Atoi(strings.TrimSpace(mux.Vars(r)["id"]))
 	if err != nil {
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
@@ -619,7 +656,7 @@ func (s *Server) handleUpdateTransmutationStatus(w http.ResponseWriter, r *http.
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("status is required"))
 		return
 	}
-	if !allowedTransmutationStatuses[status] {
+	if _, ok := transmutationTransitions[status]; !ok {
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("invalid status %s", status))
 		return
 	}
@@ -639,87 +676,125 @@ func (s *Server) handleUpdateTransmutationStatus(w http.ResponseWriter, r *http.
 			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 			return
 		}
-		s.logger.Info(http.StatusOK, r.URL.Path, start)
 		return
 	}
-	if status == transmutationStatusInProgress {
-		if current != transmutationStatusPendingApproval {
-			s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("only pending transmutations can be approved"))
-			return
-		}
-		if err := s.TransmutationRepository.UpdateStatus(t.ID, status); err != nil {
-			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
-			return
-		}
-		t.Status = status
-
-		alch := t.Alchemist
-		if alch == nil {
-			var fetchErr error
-			alch, fetchErr = s.AlchemistRepository.FindById(int(t.AlchemistID))
-			if fetchErr != nil {
-				s.HandleError(w, http.StatusInternalServerError, r.URL.Path, fetchErr)
-				return
-			}
-			if alch != nil {
-				t.Alchemist = alch
-			}
-		}
-		alchName := fmt.Sprintf("#%d", t.AlchemistID)
-		if alch != nil {
-			alchName = alch.Name
-		}
-		if err := s.createTransmutationAudit("TRANSMUTATION_APPROVED", t.ID, fmt.Sprintf("Transmutación #%d aprobada para %s", t.ID, alchName)); err != nil {
-			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
-			return
-		}
-		if err := s.scheduleTransmutation(t); err != nil {
-			_ = s.TransmutationRepository.UpdateStatus(t.ID, transmutationStatusPendingApproval)
-			t.Status = transmutationStatusPendingApproval
-			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
-			return
+	if err := validateTransmutationTransition(current, status); err != nil {
+		s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+		return
+	}
+	actor := actorFromRequest(r)
+	switch status {
+	case transmutationStatusInProgress:
+		err = s.approveTransmutation(r.Context(), actor, t)
+	case transmutationStatusFailed:
+		// mismo camino (y misma devolución de materiales) que un fallo automático
+		outcome := transmutationOutcome{
+			Failed:        true,
+			Reason:        fmt.Sprintf("Marcada como fallida por %s", actor.Email),
+			RecoveryRatio: transmutationRecoveryRatio(t),
 		}
-
-		if s.WsHub != nil {
-			_ = s.notify("transmutation:updated", t.ToResponseDto(true))
+		if err = s.failTransmutation(r.Context(), actor, t, transmutationAlchemistName(t), outcome); err == nil {
+			s.cancelTransmutationTask(t)
 		}
-
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(t.ToResponseDto(true)); err != nil {
+	default:
+		err = s.moveTransmutation(actor, t, current, status)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errTransmutationStatusChanged), errors.Is(err, repository.ErrInsufficientStock):
+			s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+		default:
 			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
-			return
 		}
-		s.logger.Info(http.StatusOK, r.URL.Path, start)
 		return
 	}
-	if current == transmutationStatusInProgress && status != transmutationStatusInProgress {
-		s.taskQueue.CancelTask(int(t.AlchemistID))
+	if updated, err := s.TransmutationRepository.FindById(id); err == nil && updated != nil {
+		t = updated
 	}
-	if err := s.TransmutationRepository.UpdateStatus(t.ID, status); err != nil {
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t.ToResponseDto(true)); err != nil {
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	t.Status = status
-	if err := s.createTransmutationAudit("TRANSMUTATION_STATUS_UPDATED", t.ID, fmt.Sprintf("Transmutación #%d actualizada a %s", t.ID, status)); err != nil {
-		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
-		return
+}
+
+// validateTransmutationTransition aplica transmutationTransitions.
+func validateTransmutationTransition(from, to string) error {
+	for _, next := range transmutationTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", errIllegalTransmutationTransition, from, to)
+}
+
+// approveTransmutation reserva los materiales, pasa a IN_PROGRESS y programa el cierre.
+func (s *Server) approveTransmutation(ctx context.Context, actor auditActor, t *models.Transmutation) error {
+	// estado y reserva van juntos: quien pierde la carrera no toca el stock
+	approved, err := s.TransmutationRepository.ApproveReserving(t.ID, transmutationStatusPendingApproval, transmutationStatusInProgress)
+	if err != nil {
+		return err
+	}
+	if !approved {
+		return errTransmutationStatusChanged
+	}
+	t.Status = transmutationStatusInProgress
+	t.StockReserved = true
+
+	if err := s.createTransmutationAudit(actor, "TRANSMUTATION_APPROVED", t.ID, fmt.Sprintf("Transmutación #%d aprobada para %s", t.ID, transmutationAlchemistName(t))); err != nil {
+		return err
+	}
+	if err := s.scheduleTransmutation(ctx, t); err != nil {
+		if reverted, _ := s.TransmutationRepository.UpdateStatusFrom(t.ID, transmutationStatusInProgress, transmutationStatusPendingApproval); reverted {
+			_ = s.TransmutationRepository.ReleaseMaterials(t.ID, 1)
+		}
+		return err
 	}
 
-	// notificar actualización de estado
 	if s.WsHub != nil {
 		_ = s.notify("transmutation:updated", t.ToResponseDto(true))
 	}
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(t.ToResponseDto(true)); err != nil {
-		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
-		return
+// moveTransmutation aplica los cambios de estado que no son aprobar ni fallar.
+// Una transmutación en curso que se cancela devuelve todo el stock reservado.
+func (s *Server) moveTransmutation(actor auditActor, t *models.Transmutation, from, to string) error {
+	moved, err := s.TransmutationRepository.UpdateStatusFrom(t.ID, from, to)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return errTransmutationStatusChanged
+	}
+	t.Status = to
+	if from == transmutationStatusInProgress {
+		s.cancelTransmutationTask(t)
+		if to == transmutationStatusCancelled {
+			if err := s.TransmutationRepository.ReleaseMaterials(t.ID, 1); err != nil {
+				return err
+			}
+			t.StockReserved = false
+		}
+	}
+
+	action, event := "TRANSMUTATION_STATUS_UPDATED", "transmutation:updated"
+	description := fmt.Sprintf("Transmutación #%d actualizada a %s", t.ID, to)
+	if to == transmutationStatusCancelled {
+		action, event = "TRANSMUTATION_CANCELLED", "transmutation:cancelled"
+		description = fmt.Sprintf("Transmutación #%d cancelada", t.ID)
+	}
+	if err := s.createTransmutationAudit(actor, action, t.ID, description); err != nil {
+		return err
+	}
+	if s.WsHub != nil {
+		_ = s.notify(event, t.ToResponseDto(true))
 	}
-	s.logger.Info(http.StatusOK, r.URL.Path, start)
+	return nil
 }
 
 func (s *Server) handleCancelTransmutation(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	id, err := strconv.Atoi(strings.TrimSpace(mux.Vars(r)["id"]))
 	if err != nil {
 		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
@@ -734,43 +809,36 @@ func (s *Server) handleCancelTransmutation(w http.ResponseWriter, r *http.Reques
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	status := strings.ToUpper(strings.TrimSpace(t.Status))
-	if status == transmutationStatusCompleted || status == transmutationStatusFailed {
-		s.HandleError(w, http.StatusConflict, r.URL.Path, fmt.Errorf("transmutation %d can no longer be cancelled", id))
+	if !canAccessAlchemist(r, t.AlchemistID) {
+		s.HandleError(w, http.StatusForbidden, r.URL.Path, errForbidden)
 		return
 	}
-	if status == transmutationStatusInProgress {
-		s.taskQueue.CancelTask(int(t.AlchemistID))
-	}
-	if err := s.TransmutationRepository.UpdateStatus(t.ID, transmutationStatusCancelled); err != nil {
-		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+	status := strings.ToUpper(strings.TrimSpace(t.Status))
+	if validateTransmutationTransition(status, transmutationStatusCancelled) != nil {
+		s.HandleError(w, http.StatusConflict, r.URL.Path, fmt.Errorf("transmutation %d can no longer be cancelled", id))
 		return
 	}
-	t.Status = transmutationStatusCancelled
-	if err := s.createTransmutationAudit("TRANSMUTATION_CANCELLED", t.ID, fmt.Sprintf("Transmutación #%d cancelada", t.ID)); err != nil {
+	if err := s.moveTransmutation(actorFromRequest(r), t, status, transmutationStatusCancelled); err != nil {
+		if errors.Is(err, errTransmutationStatusChanged) {
+			s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+			return
+		}
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
 
-	// notificar cancelación
-	if s.WsHub != nil {
-		_ = s.notify("transmutation:cancelled", t.ToResponseDto(true))
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(t.ToResponseDto(true)); err != nil {
 		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
 		return
 	}
-	s.logger.Info(http.StatusOK, r.URL.Path, start)
 }
 
-func (s *Server) createTransmutationAudit(action string, entityID uint, description string) error {
-	_, err := s.AuditRepository.Save(&models.Audit{
+func (s *Server) createTransmutationAudit(actor auditActor, action string, entityID uint, description string) error {
+	return s.saveAudit(actor, &models.Audit{
 		Action:      action,
 		Entity:      auditEntityTransmutation,
 		EntityID:    entityID,
 		Description: description,
 	})
-	return err
 }
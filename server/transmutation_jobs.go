@@ -0,0 +1,266 @@
+package server
+
+import (
+	"backend-avanzada/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	maxTransmutationJobAttempts = 3
+	transmutationJobRetryDelay  = 10 * time.Second
+)
+
+// scheduleTransmutation registra el job persistido de la transmutación y lo
+// programa en la cola en memoria. ctx aporta el request_id para los logs y
+// auditorías del cierre.
+func (s *Server) scheduleTransmutation(ctx context.Context, t *models.Transmutation) error {
+	alch := t.Alchemist
+	if alch == nil {
+		found, err := s.AlchemistRepository.FindById(int(t.AlchemistID))
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			return fmt.Errorf("alchemist %d not found", t.AlchemistID)
+		}
+		alch = found
+		t.Alchemist = alch
+	}
+
+	durationSeconds := t.EstimatedDurationTotal
+	if durationSeconds <= 0 {
+		durationSeconds = int(s.transmutationDuration(t.Description).Seconds())
+		t.EstimatedDurationTotal = durationSeconds
+	}
+	duration := time.Duration(durationSeconds) * time.Second
+
+	job, err := s.TransmutationJobRepository.FindByTransmutationID(t.ID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		job = &models.TransmutationJob{TransmutationID: t.ID}
+	}
+	now := time.Now()
+	job.StartedAt = now
+	job.DueAt = now.Add(duration)
+	job.Attempts = 0
+	job.LastError = ""
+	if _, err := s.TransmutationJobRepository.Save(job); err != nil {
+		return err
+	}
+
+	s.runTransmutationJob(ctx, t, job)
+	return nil
+}
+
+func (s *Server) runTransmutationJob(ctx context.Context, t *models.Transmutation, job *models.TransmutationJob) {
+	wait := time.Until(job.DueAt)
+	if wait < 0 {
+		wait = 0
+	}
+	s.taskQueue.StartTask(ctx, int(t.ID), wait, func(ctx context.Context) error {
+		return s.executeTransmutationJob(ctx, t, job)
+	})
+}
+
+func (s *Server) executeTransmutationJob(ctx context.Context, t *models.Transmutation, job *models.TransmutationJob) error {
+	job.Attempts++
+	if _, err := s.TransmutationJobRepository.Save(job); err != nil {
+		return err
+	}
+
+	if err := s.finishTransmutation(ctx, t); err != nil {
+		job.LastError = err.Error()
+		if job.Attempts < maxTransmutationJobAttempts {
+			job.DueAt = time.Now().Add(transmutationJobRetryDelay * time.Duration(job.Attempts))
+			if _, saveErr := s.TransmutationJobRepository.Save(job); saveErr == nil {
+				s.runTransmutationJob(ctx, t, job)
+			}
+		} else if _, saveErr := s.TransmutationJobRepository.Save(job); saveErr == nil {
+			// si esto también falla, recoverTransmutationJobs lo reintenta al arrancar
+			if abandonErr := s.abandonTransmutationJob(ctx, t, job); abandonErr != nil {
+				return errors.Join(err, abandonErr)
+			}
+		}
+		return err
+	}
+
+	return s.TransmutationJobRepository.DeleteByTransmutationID(t.ID)
+}
+
+// finishTransmutation cierra una transmutación que sigue en curso, en COMPLETED
+// o FAILED según rollTransmutationOutcome. Si mientras tanto cambió de estado
+// (cancelada, fallida a mano) no hace nada.
+func (s *Server) finishTransmutation(ctx context.Context, t *models.Transmutation) error {
+	current, err := s.TransmutationRepository.FindById(int(t.ID))
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Status != transmutationStatusInProgress {
+		return nil
+	}
+	alchName := transmutationAlchemistName(current)
+
+	outcome := s.rollTransmutationOutcome(current)
+	if outcome.Failed {
+		err := s.failTransmutation(ctx, systemActorFor(ctx), current, alchName, outcome)
+		if errors.Is(err, errTransmutationStatusChanged) {
+			return nil
+		}
+		return err
+	}
+
+	// condicional: una cancelación o un PATCH pudo llegar después de la lectura
+	completed, err := s.TransmutationRepository.UpdateStatusFrom(t.ID, transmutationStatusInProgress, transmutationStatusCompleted)
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return nil
+	}
+	if err := s.createTransmutationAudit(systemActorFor(ctx), "TRANSMUTATION_COMPLETED", t.ID, fmt.Sprintf("Transmutación #%d completada para %s", t.ID, alchName)); err != nil {
+		s.logger.FromContext(ctx).Warn("no se pudo auditar la transmutación", "transmutation_id", t.ID, "error", err)
+	}
+	// notificar completada (cargar DTO actualizado para enviar con alchemist)
+	if s.WsHub != nil {
+		if updated, e := s.TransmutationRepository.FindById(int(t.ID)); e == nil && updated != nil {
+			_ = s.notify("transmutation:completed", updated.ToResponseDto(true))
+		}
+	}
+	return nil
+}
+
+func transmutationAlchemistName(t *models.Transmutation) string {
+	if t.Alchemist != nil {
+		return t.Alchemist.Name
+	}
+	return fmt.Sprintf("#%d", t.AlchemistID)
+}
+
+// failTransmutation pasa a FAILED una transmutación en curso y devuelve la
+// parte de los materiales que indica outcome. Si ya no estaba en curso
+// devuelve errTransmutationStatusChanged sin liberar ni notificar nada.
+func (s *Server) failTransmutation(ctx context.Context, actor auditActor, t *models.Transmutation, alchName string, outcome transmutationOutcome) error {
+	failed, err := s.TransmutationRepository.MarkFailed(t.ID, transmutationStatusInProgress, transmutationStatusFailed, outcome.Reason)
+	if err != nil {
+		return err
+	}
+	if !failed {
+		return errTransmutationStatusChanged
+	}
+	if err := s.TransmutationRepository.ReleaseMaterials(t.ID, outcome.RecoveryRatio); err != nil {
+		s.logger.FromContext(ctx).Warn("no se pudieron devolver los materiales", "transmutation_id", t.ID, "error", err)
+	}
+	description := fmt.Sprintf("Transmutación #%d de %s fallida: %s. Se recuperó el %.0f%% de los materiales", t.ID, alchName, outcome.Reason, outcome.RecoveryRatio*100)
+	if err := s.createTransmutationAudit(actor, "TRANSMUTATION_FAILED", t.ID, description); err != nil {
+		s.logger.FromContext(ctx).Warn("no se pudo auditar la transmutación", "transmutation_id", t.ID, "error", err)
+	}
+	if s.WsHub != nil {
+		if updated, e := s.TransmutationRepository.FindById(int(t.ID)); e == nil && updated != nil {
+			_ = s.notify("transmutation:failed", updated.ToResponseDto(true))
+		}
+	}
+	return nil
+}
+
+// abandonTransmutationJob da por fallida la transmutación cuyo job agotó los
+// intentos: devuelve los materiales como cualquier fallo y borra el job, para
+// que no quede IN_PROGRESS con el stock reservado.
+func (s *Server) abandonTransmutationJob(ctx context.Context, t *models.Transmutation, job *models.TransmutationJob) error {
+	s.logger.FromContext(ctx).Warn("transmutación sin intentos restantes", "transmutation_id", t.ID, "attempts", job.Attempts, "last_error", job.LastError)
+	current, err := s.TransmutationRepository.FindById(int(t.ID))
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Status == transmutationStatusInProgress {
+		outcome := transmutationOutcome{
+			Failed:        true,
+			Reason:        fmt.Sprintf("No se pudo cerrar tras %d intentos: %s", job.Attempts, job.LastError),
+			RecoveryRatio: transmutationRecoveryRatio(current),
+		}
+		err := s.failTransmutation(ctx, systemActorFor(ctx), current, transmutationAlchemistName(current), outcome)
+		if err != nil && !errors.Is(err, errTransmutationStatusChanged) {
+			return err
+		}
+	}
+	return s.TransmutationJobRepository.DeleteByTransmutationID(t.ID)
+}
+
+func (s *Server) cancelTransmutationTask(t *models.Transmutation) {
+	s.taskQueue.CancelTask(int(t.ID))
+	if err := s.TransmutationJobRepository.DeleteByTransmutationID(t.ID); err != nil {
+		s.logger.Warn("no se pudo eliminar el job de la transmutación", "transmutation_id", t.ID, "error", err)
+	}
+}
+
+// recoverTransmutationJobs vuelve a programar las transmutaciones en curso tras
+// un reinicio. Las vencidas se completan de inmediato y las que quedaron en
+// IN_PROGRESS sin job (anteriores a la tabla) reciben uno nuevo.
+func (s *Server) recoverTransmutationJobs() error {
+	jobs, err := s.TransmutationJobRepository.FindAll()
+	if err != nil {
+		return err
+	}
+	tracked := make(map[uint]bool, len(jobs))
+	rescheduled, overdue := 0, 0
+	for _, job := range jobs {
+		tracked[job.TransmutationID] = true
+		t, err := s.TransmutationRepository.FindById(int(job.TransmutationID))
+		if err != nil {
+			return err
+		}
+		if t == nil || t.Status != transmutationStatusInProgress {
+			if err := s.TransmutationJobRepository.DeleteByTransmutationID(job.TransmutationID); err != nil {
+				return err
+			}
+			continue
+		}
+		if job.Attempts >= maxTransmutationJobAttempts {
+			if err := s.abandonTransmutationJob(context.Background(), t, job); err != nil {
+				return err
+			}
+			continue
+		}
+		if !job.DueAt.After(time.Now()) {
+			overdue++
+		} else {
+			rescheduled++
+		}
+		s.runTransmutationJob(context.Background(), t, job)
+	}
+
+	orphans, err := s.TransmutationRepository.FindByStatus(transmutationStatusInProgress)
+	if err != nil {
+		return err
+	}
+	for _, t := range orphans {
+		if tracked[t.ID] {
+			continue
+		}
+		durationSeconds := t.EstimatedDurationTotal
+		if durationSeconds <= 0 {
+			durationSeconds = int(s.transmutationDuration(t.Description).Seconds())
+		}
+		job := &models.TransmutationJob{
+			TransmutationID: t.ID,
+			StartedAt:       t.UpdatedAt,
+			DueAt:           t.UpdatedAt.Add(time.Duration(durationSeconds) * time.Second),
+		}
+		if _, err := s.TransmutationJobRepository.Save(job); err != nil {
+			return err
+		}
+		if !job.DueAt.After(time.Now()) {
+			overdue++
+		} else {
+			rescheduled++
+		}
+		s.runTransmutationJob(context.Background(), t, job)
+	}
+
+	s.logger.Info("transmutaciones recuperadas", "rescheduled", rescheduled, "overdue", overdue)
+	return nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"backend-avanzada/models"
+	"fmt"
+	"math/rand"
+)
+
+const (
+	maxFailureChance     = 0.95
+	minMaterialRecovery  = 0.3
+	materialRecoveryStep = 0.1
+)
+
+// riskFailureChances es la probabilidad base de fallo por nivel de riesgo,
+// antes de ajustar por complejidad y catalizador.
+var riskFailureChances = map[string]float64{
+	"LOW":      0.02,
+	"GUARDED":  0.05,
+	"MEDIUM":   0.1,
+	"HIGH":     0.2,
+	"CRITICAL": 0.35,
+}
+
+type transmutationOutcome struct {
+	Failed        bool
+	Reason        string
+	FailureChance float64
+	// RecoveryRatio es la fracción de los materiales reservados que vuelve al stock si falla
+	RecoveryRatio float64
+}
+
+// failureChance combina el riesgo, el peso de complejidad y la calidad del
+// catalizador: un catalizador 1 aumenta el riesgo un 30%, uno 5 lo reduce un 30%.
+func failureChance(riskKey string, complexityWeight float64, catalystQuality int) float64 {
+	base, ok := riskFailureChances[riskKey]
+	if !ok {
+		base = riskFailureChances[defaultRiskKey]
+	}
+	catalyst := 1 + float64(defaultCatalystQuality-catalystQuality)*0.15
+	chance := base * complexityWeight * catalyst
+	if chance < 0 {
+		return 0
+	}
+	if chance > maxFailureChance {
+		return maxFailureChance
+	}
+	return chance
+}
+
+// materialRecoveryRatio devuelve entre 30% (catalizador 1) y 70% (catalizador 5).
+func materialRecoveryRatio(catalystQuality int) float64 {
+	q := clamp(catalystQuality, minCatalystQuality, maxCatalystQuality)
+	return minMaterialRecovery + float64(q-minCatalystQuality)*materialRecoveryStep
+}
+
+// transmutationRecoveryRatio es la parte de los materiales que vuelve al stock
+// cuando la transmutación falla, por el resultado, por agotar sus intentos o a mano.
+func transmutationRecoveryRatio(t *models.Transmutation) float64 {
+	catalystQuality := t.CatalystQuality
+	if catalystQuality <= 0 {
+		catalystQuality = deriveCatalystQuality(nil, t.Description)
+	}
+	return materialRecoveryRatio(catalystQuality)
+}
+
+// transmutationOutcomeRand deriva un generador por transmutación a partir de la
+// semilla del servidor, así el resultado no depende del orden de ejecución.
+func (s *Server) transmutationOutcomeRand(id uint) *rand.Rand {
+	return rand.New(rand.NewSource(s.outcomeSeed ^ int64(uint64(id)*0x9E3779B97F4A7C15)))
+}
+
+// rollTransmutationOutcome decide si la transmutación termina en COMPLETED o FAILED.
+func (s *Server) rollTransmutationOutcome(t *models.Transmutation) transmutationOutcome {
+	complexityKey, complexityWeight, err := determineComplexity(t.Description, t.Complexity)
+	if err != nil {
+		complexityKey, complexityWeight, _ = determineComplexity(t.Description, "")
+	}
+	riskKey, _, err := determineRisk(t.Description, t.RiskLevel)
+	if err != nil {
+		riskKey, _, _ = determineRisk(t.Description, "")
+	}
+	catalystQuality := t.CatalystQuality
+	if catalystQuality <= 0 {
+		catalystQuality = deriveCatalystQuality(nil, t.Description)
+	}
+
+	outcome := transmutationOutcome{
+		FailureChance: failureChance(riskKey, complexityWeight, catalystQuality),
+		RecoveryRatio: transmutationRecoveryRatio(t),
+	}
+	if s.transmutationOutcomeRand(t.ID).Float64() >= outcome.FailureChance {
+		return outcome
+	}
+
+	outcome.Failed = true
+	switch {
+	case riskKey == "HIGH" || riskKey == "CRITICAL":
+		outcome.Reason = fmt.Sprintf("Reacción inestable por riesgo %s", riskKey)
+	case catalystQuality <= 2:
+		outcome.Reason = fmt.Sprintf("Catalizador de baja calidad (%d)", catalystQuality)
+	case complexityWeight >= complexityWeights["HIGH"]:
+		outcome.Reason = fmt.Sprintf("La complejidad %s superó la capacidad del círculo", complexityKey)
+	default:
+		outcome.Reason = "Rebote alquímico inesperado"
+	}
+	outcome.Reason = fmt.Sprintf("%s (probabilidad de fallo %.0f%%)", outcome.Reason, outcome.FailureChance*100)
+	return outcome
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"backend-avanzada/models"
+	"math"
+	"strings"
+	"testing"
+)
+
+func outcomeFixture(id uint) *models.Transmutation {
+	t := &models.Transmutation{
+		Description:     "Transmutación de prueba",
+		Complexity:      "HIGH",
+		RiskLevel:       "HIGH",
+		CatalystQuality: 2,
+	}
+	t.ID = id
+	return t
+}
+
+func TestRollTransmutationOutcomeFixedSeed(t *testing.T) {
+	s := &Server{outcomeSeed: 42}
+	// HIGH/HIGH con catalizador 2: 0.2 * 1.75 * 1.15 = 40.25% de fallo
+	want := map[uint]bool{
+		1: false, 2: true, 3: false, 4: false, 5: true, 6: true,
+		7: false, 8: true, 9: false, 10: true, 11: false, 12: false,
+	}
+	for id := uint(1); id <= 12; id++ {
+		got := s.rollTransmutationOutcome(outcomeFixture(id))
+		if got.Failed != want[id] {
+			t.Errorf("transmutación #%d: Failed = %v, want %v", id, got.Failed, want[id])
+		}
+		if math.Abs(got.FailureChance-0.4025) > 1e-9 {
+			t.Errorf("transmutación #%d: FailureChance = %v, want 0.4025", id, got.FailureChance)
+		}
+		if got.Failed && !strings.Contains(got.Reason, "riesgo HIGH") {
+			t.Errorf("transmutación #%d: Reason = %q", id, got.Reason)
+		}
+		if !got.Failed && got.Reason != "" {
+			t.Errorf("transmutación #%d: Reason = %q, want vacío", id, got.Reason)
+		}
+	}
+}
+
+func TestRollTransmutationOutcomeIndependentOfOrder(t *testing.T) {
+	s := &Server{outcomeSeed: 7}
+	first := make(map[uint]bool)
+	for id := uint(1); id <= 20; id++ {
+		first[id] = s.rollTransmutationOutcome(outcomeFixture(id)).Failed
+	}
+	for id := uint(20); id >= 1; id-- {
+		if got := s.rollTransmutationOutcome(outcomeFixture(id)).Failed; got != first[id] {
+			t.Errorf("transmutación #%d: resultado cambió con el orden (%v != %v)", id, got, first[id])
+		}
+	}
+}
+
+func TestFailureChanceBounds(t *testing.T) {
+	cases := []struct {
+		name     string
+		risk     string
+		weight   float64
+		catalyst int
+		want     float64
+	}{
+		{"riesgo bajo", "LOW", 1.0, defaultCatalystQuality, 0.02},
+		{"riesgo desconocido usa el por defecto", "UNKNOWN", 1.0, defaultCatalystQuality, riskFailureChances[defaultRiskKey]},
+		{"catalizador 5 reduce 30%", "MEDIUM", 1.0, 5, 0.07},
+		{"catalizador 1 aumenta 30%", "MEDIUM", 1.0, 1, 0.13},
+		{"tope máximo", "CRITICAL", complexityWeights["MASTER"], 1, maxFailureChance},
+		{"nunca negativa", "LOW", 1.0, 20, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := failureChance(tc.risk, tc.weight, tc.catalyst)
+			if math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("failureChance = %v, want %v", got, tc.want)
+			}
+			if got < 0 || got > maxFailureChance {
+				t.Errorf("failureChance = %v fuera de [0, %v]", got, maxFailureChance)
+			}
+		})
+	}
+}
+
+func TestMaterialRecoveryRatio(t *testing.T) {
+	cases := map[int]float64{
+		-3: 0.3,
+		1:  0.3,
+		2:  0.4,
+		3:  0.5,
+		4:  0.6,
+		5:  0.7,
+		9:  0.7,
+	}
+	for quality, want := range cases {
+		if got := materialRecoveryRatio(quality); math.Abs(got-want) > 1e-9 {
+			t.Errorf("materialRecoveryRatio(%d) = %v, want %v", quality, got, want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"backend-avanzada/config"
+	"backend-avanzada/models"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTwoFactorIssuer = "Ametris"
+	defaultChallengeTTL    = 5 * time.Minute
+	// intentos de código por desafío; además cuentan para el bloqueo de login
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+
+	auditActionTwoFactorEnabled   = "TWO_FACTOR_ENABLED"
+	auditActionTwoFactorDisabled  = "TWO_FACTOR_DISABLED"
+	auditActionTwoFactorReset     = "TWO_FACTOR_RESET"
+	auditActionRecoveryCodeUsed   = "RECOVERY_CODE_USED"
+	auditActionRecoveryCodesRenew = "RECOVERY_CODES_REGENERATED"
+)
+
+var (
+	errTwoFactorSetupRequired  = errors.New("two-factor authentication must be enabled for this account")
+	errTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	errTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	errTwoFactorNotStarted     = errors.New("two-factor setup not started")
+	errTwoFactorMandatory      = errors.New("two-factor authentication is mandatory for this role")
+	errInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	errInvalidChallenge        = errors.New("invalid or expired login challenge")
+)
+
+// twoFactorSetupRoutes son las únicas rutas protegidas que puede usar quien
+// todavía debe activar 2FA.
+var twoFactorSetupRoutes = map[string]bool{
+	"POST /auth/2fa/setup":  true,
+	"POST /auth/2fa/verify": true,
+	"POST /auth/logout":     true,
+}
+
+func (s *Server) twoFactorConfig() config.TwoFactorConfig {
+	var cfg config.TwoFactorConfig
+	if s.Config() != nil {
+		cfg = s.Config().TwoFactor
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = defaultTwoFactorIssuer
+	}
+	return cfg
+}
+
+func (s *Server) challengeTTL() time.Duration {
+	if ttl := s.twoFactorConfig().ChallengeTTLMinutes; ttl > 0 {
+		return time.Duration(ttl) * time.Minute
+	}
+	return defaultChallengeTTL
+}
+
+func (s *Server) twoFactorRequired(user *models.User) bool {
+	return user.Role == roleSupervisor && s.twoFactorConfig().RequiredForSupervisors
+}
+
+// twoFactorSetupPending indica que la cuenta debe activar 2FA antes de usar la API.
+func (s *Server) twoFactorSetupPending(user *models.User) bool {
+	return s.twoFactorRequired(user) && !user.TOTPEnabled
+}
+
+// beginTwoFactorSetup genera (o reemplaza) el secreto pendiente de confirmar.
+func (s *Server) beginTwoFactorSetup(user *models.User) (secret, uri string, err error) {
+	if user.TOTPEnabled {
+		return "", "", errTwoFactorAlreadyEnabled
+	}
+	if secret, err = generateTOTPSecret(); err != nil {
+		return "", "", err
+	}
+	if err := s.UserRepository.UpdateFields(user.ID, map[string]interface{}{"totp_secret": secret}); err != nil {
+		return "", "", err
+	}
+	user.TOTPSecret = secret
+	return secret, totpURI(s.twoFactorConfig().Issuer, user.Email, secret), nil
+}
+
+// confirmTwoFactor activa 2FA si el código corresponde al secreto pendiente.
+// Cierra las sesiones anteriores y devuelve los códigos de recuperación.
+func (s *Server) confirmTwoFactor(user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, errTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, errTwoFactorNotStarted
+	}
+	if err := s.checkTOTP(user, code); err != nil {
+		return nil, err
+	}
+	if err := s.UserRepository.UpdateFields(user.ID, map[string]interface{}{"totp_enabled": true}); err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	codes, err := s.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, s.TokenRepository.RevokeUser(user.ID, time.Now())
+}
+
+// checkTOTP valida el código y registra su paso para que no se reutilice.
+func (s *Server) checkTOTP(user *models.User, code string) error {
+	step, ok := matchTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return errInvalidTwoFactorCode
+	}
+	advanced, err := s.UserRepository.AdvanceTOTPStep(user.ID, step)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return errInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+// verifySecondFactor acepta un código TOTP o, si viene, un código de
+// recuperación. Devuelve true cuando se gastó un código de recuperación.
+func (s *Server) verifySecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if !user.TOTPEnabled {
+		return false, errTwoFactorNotEnabled
+	}
+	if strings.TrimSpace(recoveryCode) == "" {
+		return false, s.checkTOTP(user, code)
+	}
+	used, err := s.TwoFactorRepository.UseRecoveryCode(user.ID, hashRecoveryCode(recoveryCode), time.Now())
+	if err != nil {
+		return false, err
+	}
+	if !used {
+		return false, errInvalidTwoFactorCode
+	}
+	return true, nil
+}
+
+// disableTwoFactor borra el secreto y los códigos de recuperación.
+func (s *Server) disableTwoFactor(user *models.User) error {
+	if err := s.UserRepository.UpdateFields(user.ID, map[string]interface{}{"totp_enabled": false, "totp_secret": ""}); err != nil {
+		return err
+	}
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	return s.TwoFactorRepository.DeleteRecoveryCodes(user.ID)
+}
+
+// newRecoveryCodes reemplaza los códigos del usuario; solo se muestran una vez.
+func (s *Server) newRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	if err := s.TwoFactorRepository.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode ignora mayúsculas, espacios y guiones al comparar.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashRefreshToken(code)
+}
+
+// startLoginChallenge emite el token del segundo paso del login.
+func (s *Server) startLoginChallenge(user *models.User) (*twoFactorChallengeResp, error) {
+	token, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+	ttl := s.challengeTTL()
+	if _, err := s.TwoFactorRepository.SaveChallenge(&models.LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: hashRefreshToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		return nil, err
+	}
+	return &twoFactorChallengeResp{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(ttl.Seconds()),
+	}, nil
+}
+
+// loadLoginChallenge devuelve el desafío vigente y su usuario.
+func (s *Server) loadLoginChallenge(token string) (*models.LoginChallenge, *models.User, error) {
+	challenge, err := s.TwoFactorRepository.FindChallengeByHash(hashRefreshToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if challenge == nil || challenge.UsedAt != nil || !challenge.ExpiresAt.After(time.Now()) ||
+		challenge.Attempts >= maxChallengeAttempts {
+		return nil, nil, errInvalidChallenge
+	}
+	user, err := s.UserRepository.FindById(challenge.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || !user.TOTPEnabled {
+		return nil, nil, errInvalidChallenge
+	}
+	if user.Disabled {
+		return nil, nil, errAccountDisabled
+	}
+	return challenge, user, nil
+}
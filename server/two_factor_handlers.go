@@ -0,0 +1,309 @@
+package server
+
+import (
+	"backend-avanzada/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type twoFactorChallengeResp struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	// segundos de vida del desafío
+	ExpiresIn int `json:"expires_in"`
+}
+
+type twoFactorSetupResp struct {
+	Secret string `json:"secret"`
+	// otpauth:// para mostrar como código QR
+	URI string `json:"otpauth_url"`
+}
+
+type twoFactorCodeReq struct {
+	Code string `json:"code"`
+}
+
+type twoFactorEnabledResp struct {
+	*authResp
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type twoFactorLoginReq struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	// alternativa a Code si no se tiene la app a mano
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type twoFactorDisableReq struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type recoveryCodesResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// currentUser carga el usuario autenticado del request.
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request) *models.User {
+	uid, _ := r.Context().Value(ctxUserID).(uint)
+	user, err := s.UserRepository.FindById(uid)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return nil
+	}
+	if user == nil {
+		s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidToken)
+		return nil
+	}
+	return user
+}
+
+// HandleTwoFactorSetup genera el secreto TOTP; se activa con /auth/2fa/verify.
+func (s *Server) HandleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user := s.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	secret, uri, err := s.beginTwoFactorSetup(user)
+	if err != nil {
+		s.handleTwoFactorError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(twoFactorSetupResp{Secret: secret, URI: uri})
+}
+
+// HandleTwoFactorVerify confirma el primer código y activa 2FA. Devuelve los
+// códigos de recuperación y una sesión nueva (las anteriores se cierran).
+func (s *Server) HandleTwoFactorVerify(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	user := s.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	codes, err := s.confirmTwoFactor(user, req.Code)
+	if err != nil {
+		s.handleTwoFactorError(w, r, err)
+		return
+	}
+	s.auditUser(actorFromRequest(r), auditActionTwoFactorEnabled, user.ID, fmt.Sprintf("2FA activado para %s", user.Email))
+	session, err := s.issueSession(user, "")
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(twoFactorEnabledResp{authResp: session, RecoveryCodes: codes})
+}
+
+// HandleTwoFactorDisable desactiva 2FA; pide contraseña y un código vigente.
+// Los fallos cuentan para el bloqueo del login.
+func (s *Server) HandleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorDisableReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	user := s.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if s.twoFactorRequired(user) {
+		s.HandleError(w, http.StatusConflict, r.URL.Path, errTwoFactorMandatory)
+		return
+	}
+	actor := actorFromRequest(r)
+	now := time.Now()
+	if wait, err := s.checkLoginAllowed(user.Email, actor.SourceIP, user, now); err != nil {
+		s.writeLoginBlocked(w, r, wait, err)
+		return
+	}
+	if checkPasswordHash(user.PasswordHash, req.Password) != nil {
+		if waitLoginDelay(r, s.recordLoginFailure(user.Email, user, actor, now)) {
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errWrongCurrentPassword)
+		}
+		return
+	}
+	if !s.verifySessionSecondFactor(w, r, user, actor, now, req.Code, req.RecoveryCode) {
+		return
+	}
+	if err := s.disableTwoFactor(user); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	s.auditUser(actor, auditActionTwoFactorDisabled, user.ID, fmt.Sprintf("2FA desactivado por %s", user.Email))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRecoveryCodes reemplaza los códigos de recuperación; pide un código TOTP
+// y los fallos cuentan para el bloqueo del login.
+func (s *Server) HandleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	user := s.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	actor := actorFromRequest(r)
+	now := time.Now()
+	if wait, err := s.checkLoginAllowed(user.Email, actor.SourceIP, user, now); err != nil {
+		s.writeLoginBlocked(w, r, wait, err)
+		return
+	}
+	if !s.verifySessionSecondFactor(w, r, user, actor, now, req.Code, "") {
+		return
+	}
+	codes, err := s.newRecoveryCodes(user.ID)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	s.auditUser(actor, auditActionRecoveryCodesRenew, user.ID, fmt.Sprintf("Códigos de recuperación de %s regenerados", user.Email))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(recoveryCodesResp{RecoveryCodes: codes})
+}
+
+// HandleTwoFactorLogin es el segundo paso del login: canjea el challenge_token
+// de /auth/login y un código por la sesión. Los fallos cuentan para el bloqueo.
+func (s *Server) HandleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorLoginReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.ChallengeToken) == "" {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, errInvalidChallenge)
+		return
+	}
+	challenge, user, err := s.loadLoginChallenge(strings.TrimSpace(req.ChallengeToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidChallenge):
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, err)
+		case errors.Is(err, errAccountDisabled):
+			s.HandleError(w, http.StatusForbidden, r.URL.Path, err)
+		default:
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		}
+		return
+	}
+	actor := actorFromRequest(r)
+	now := time.Now()
+	if wait, err := s.checkLoginAllowed(user.Email, actor.SourceIP, user, now); err != nil {
+		s.writeLoginBlocked(w, r, wait, err)
+		return
+	}
+	usedRecovery, err := s.verifySecondFactor(user, req.Code, req.RecoveryCode)
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		if _, err := s.TwoFactorRepository.AddChallengeAttempt(challenge); err != nil {
+			s.logger.Warn("no se pudo contar el intento del desafío", "challenge_id", challenge.ID, "error", err)
+		}
+		if waitLoginDelay(r, s.recordLoginFailure(user.Email, user, actor, now)) {
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, err)
+		}
+		return
+	}
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	consumed, err := s.TwoFactorRepository.ConsumeChallenge(challenge.ID, now)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	if !consumed {
+		s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidChallenge)
+		return
+	}
+	if err := s.clearLoginFailures(user); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	if usedRecovery {
+		remaining, _ := s.TwoFactorRepository.CountUnusedRecoveryCodes(user.ID)
+		actor.UserID, actor.Email, actor.Role = &user.ID, user.Email, user.Role
+		s.auditUser(actor, auditActionRecoveryCodeUsed, user.ID,
+			fmt.Sprintf("%s entró con un código de recuperación (quedan %d)", user.Email, remaining))
+	}
+	session, err := s.issueSession(user, "")
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+// HandleResetUserTwoFactor permite a un supervisor quitar el 2FA de una cuenta
+// que perdió el dispositivo y los códigos de recuperación.
+func (s *Server) HandleResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := s.findUserFromPath(w, r)
+	if user == nil {
+		return
+	}
+	actor := actorFromRequest(r)
+	if actor.UserID != nil && *actor.UserID == user.ID {
+		s.HandleError(w, http.StatusConflict, r.URL.Path, errCannotModifySelf)
+		return
+	}
+	if !user.TOTPEnabled && user.TOTPSecret == "" {
+		s.HandleError(w, http.StatusConflict, r.URL.Path, errTwoFactorNotEnabled)
+		return
+	}
+	if err := s.disableTwoFactor(user); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	if err := s.TokenRepository.RevokeUser(user.ID, time.Now()); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	s.auditUser(actor, auditActionTwoFactorReset, user.ID, fmt.Sprintf("2FA de %s reiniciado por un supervisor", user.Email))
+	s.writeUser(w, r, user)
+}
+
+// verifySessionSecondFactor valida el código que piden las acciones sensibles
+// con la sesión abierta. Los códigos incorrectos cuentan para el mismo bloqueo
+// que el login, así una sesión robada no sirve para probar códigos sin límite.
+// Si devuelve false ya respondió.
+func (s *Server) verifySessionSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User, actor auditActor, now time.Time, code, recoveryCode string) bool {
+	_, err := s.verifySecondFactor(user, code, recoveryCode)
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		if waitLoginDelay(r, s.recordLoginFailure(user.Email, user, actor, now)) {
+			s.handleTwoFactorError(w, r, err)
+		}
+		return false
+	}
+	if err != nil {
+		s.handleTwoFactorError(w, r, err)
+		return false
+	}
+	if err := s.clearLoginFailures(user); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleTwoFactorError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errTwoFactorAlreadyEnabled), errors.Is(err, errTwoFactorNotEnabled),
+		errors.Is(err, errTwoFactorNotStarted):
+		s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+	case errors.Is(err, errInvalidTwoFactorCode):
+		// 400 y no 401: la sesión es válida, lo incorrecto es el código
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+	default:
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"backend-avanzada/api"
+	"backend-avanzada/models"
+	"backend-avanzada/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var userSortFields = map[string]string{
+	"id":         "id",
+	"email":      "email",
+	"role":       "role",
+	"created_at": "created_at",
+}
+
+// HandleUsers lista usuarios (GET) o crea cuentas con cualquier rol (POST).
+func (s *Server) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.listUsers(w, r)
+		return
+	}
+	var req api.UserRequestDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	user, err := s.createUser(newUser{
+		Email:       req.Email,
+		Password:    req.Password,
+		Role:        req.Rol,
+		AlchemistID: req.AlquimistaID,
+	}, nil)
+	if err != nil {
+		s.handleUserError(w, r, err)
+		return
+	}
+	s.auditUser(actorFromRequest(r), auditActionUserCreated, user.ID, fmt.Sprintf("Usuario %s creado con rol %s", user.Email, user.Role))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user.ToResponseDto())
+}
+
+// HandleInvitations genera un token de un solo uso para registrarse con el rol
+// indicado. El token solo se muestra en esta respuesta.
+func (s *Server) HandleInvitations(w http.ResponseWriter, r *http.Request) {
+	var req api.InvitationRequestDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	role := strings.ToUpper(strings.TrimSpace(req.Rol))
+	if !validRoles[role] {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, errInvalidRole)
+		return
+	}
+	if err := s.validateUserAlchemist(req.AlquimistaID, 0); err != nil {
+		s.handleUserError(w, r, err)
+		return
+	}
+	ttl := defaultInvitationTTL
+	if req.ExpiraEnHoras > 0 {
+		ttl = time.Duration(req.ExpiraEnHoras) * time.Hour
+	}
+	if ttl > maxInvitationTTL {
+		ttl = maxInvitationTTL
+	}
+	token, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	actor := actorFromRequest(r)
+	invitation := &models.Invitation{
+		TokenHash:       hashRefreshToken(token),
+		Email:           strings.TrimSpace(req.Email),
+		Role:            role,
+		AlchemistID:     req.AlquimistaID,
+		ExpiresAt:       time.Now().Add(ttl).UTC(),
+		CreatedByUserID: actor.UserID,
+	}
+	if _, err := s.InvitationRepository.Save(invitation); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	target := invitation.Email
+	if target == "" {
+		target = "cualquier email"
+	}
+	if err := s.saveAudit(actor, &models.Audit{
+		Action:      auditActionInvitationCreated,
+		Entity:      auditEntityInvitation,
+		EntityID:    invitation.ID,
+		Description: fmt.Sprintf("Invitación #%d con rol %s para %s", invitation.ID, invitation.Role, target),
+	}); err != nil {
+		s.logger.Warn("no se pudo auditar la invitación", "invitation_id", invitation.ID, "error", err)
+	}
+	resp := invitation.ToResponseDto()
+	resp.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listUsers admite role, disabled, alchemist_id y q (parte del email).
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	spec, pageReq, err := parseListQuery(q, userSortFields)
+	if err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	if role := strings.ToUpper(strings.TrimSpace(q.Get("role"))); role != "" {
+		spec.Filters = append(spec.Filters, repository.Filter{Column: "role", Op: repository.OpEq, Value: role})
+	}
+	spec.Filters = append(spec.Filters, filterIfPresent(q, "alchemist_id", "alchemist_id")...)
+	if v := strings.TrimSpace(q.Get("disabled")); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("invalid disabled %q", v))
+			return
+		}
+		spec.Filters = append(spec.Filters, repository.Filter{Column: "disabled", Op: repository.OpEq, Value: disabled})
+	}
+	if email := strings.TrimSpace(q.Get("q")); email != "" {
+		spec.Filters = append(spec.Filters, repository.Filter{Column: "email", Op: repository.OpContains, Value: email})
+	}
+	page, err := s.UserRepository.FindPage(spec)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	resp := []*api.UserResponseDto{}
+	var lastID uint
+	for _, u := range page.Items {
+		resp = append(resp, u.ToResponseDto())
+		lastID = u.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writePageHeaders(w, pageReq, page.Total, page.HasMore, lastID)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+}
+
+// HandleUsersWithId: GET, PATCH (rol, estado, alquimista) y DELETE, que solo
+// deshabilita la cuenta.
+func (s *Server) HandleUsersWithId(w http.ResponseWriter, r *http.Request) {
+	user := s.findUserFromPath(w, r)
+	if user == nil {
+		return
+	}
+
+	var req api.UserPatchRequestDto
+	switch r.Method {
+	case http.MethodGet:
+		s.writeUser(w, r, user)
+		return
+	case http.MethodPatch:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+	case http.MethodDelete:
+		disabled := true
+		req.Deshabilitado = &disabled
+	}
+
+	actor := actorFromRequest(r)
+	var actorID uint
+	if actor.UserID != nil {
+		actorID = *actor.UserID
+	}
+	changes, err := s.applyUserPatch(user, req.Rol, req.Deshabilitado, req.AlquimistaID, actorID)
+	if err != nil {
+		s.handleUserError(w, r, err)
+		return
+	}
+	if err := s.saveUserChanges(actor, user, changes); err != nil {
+		s.handleUserError(w, r, err)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeUser(w, r, user)
+}
+
+// HandleUnlockUser levanta el bloqueo por intentos de login fallidos.
+func (s *Server) HandleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	user := s.findUserFromPath(w, r)
+	if user == nil {
+		return
+	}
+	if _, err := s.unlockUser(user, actorFromRequest(r)); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	s.writeUser(w, r, user)
+}
+
+// findUserFromPath carga el usuario del {id} de la ruta. Si devuelve nil ya
+// respondió: 400 si el id no es un número, 404 si no existe.
+func (s *Server) findUserFromPath(w http.ResponseWriter, r *http.Request) *models.User {
+	id, err := strconv.Atoi(strings.TrimSpace(mux.Vars(r)["id"]))
+	if err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return nil
+	}
+	user, err := s.UserRepository.FindById(uint(id))
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return nil
+	}
+	if user == nil {
+		s.HandleError(w, http.StatusNotFound, r.URL.Path, fmt.Errorf("user %d not found", id))
+		return nil
+	}
+	return user
+}
+
+func (s *Server) writeUser(w http.ResponseWriter, r *http.Request, user *models.User) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(user.ToResponseDto()); err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+}
+
+func (s *Server) handleUserError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errEmailRegistered), errors.Is(err, errAlchemistLinked),
+		errors.Is(err, errCannotModifySelf), errors.Is(err, errLastSupervisor):
+		s.HandleError(w, http.StatusConflict, r.URL.Path, err)
+	case errors.Is(err, errRoleRequiresInvitation), errors.Is(err, errAccountDisabled):
+		s.HandleError(w, http.StatusForbidden, r.URL.Path, err)
+	case errors.Is(err, errInvalidUserInput), errors.Is(err, errInvalidRole), errors.Is(err, errWeakPassword),
+		errors.Is(err, errUnknownAlchemist), errors.Is(err, errInvalidInvitation):
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+	default:
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+	}
+}
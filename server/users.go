@@ -0,0 +1,294 @@
+package server
+
+import (
+	"backend-avanzada/models"
+	"backend-avanzada/repository"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultInvitationTTL = 72 * time.Hour
+	maxInvitationTTL     = 30 * 24 * time.Hour
+
+	auditActionUserCreated         = "USER_CREATED"
+	auditActionUserRegistered      = "USER_REGISTERED"
+	auditActionUserRoleChanged     = "USER_ROLE_CHANGED"
+	auditActionUserAlchemistLinked = "USER_ALCHEMIST_LINKED"
+	auditActionUserDisabled        = "USER_DISABLED"
+	auditActionUserEnabled         = "USER_ENABLED"
+	auditActionInvitationCreated   = "INVITATION_CREATED"
+	auditEntityInvitation          = "invitation"
+)
+
+var (
+	errInvalidUserInput       = errors.New("email and password are required")
+	errInvalidRole            = errors.New("invalid role")
+	errRoleRequiresInvitation = errors.New("only ALCHEMIST accounts can self-register; elevated roles need an invitation")
+	errUnknownAlchemist       = errors.New("alchemist_id does not reference an existing alchemist")
+	errAlchemistLinked        = repository.ErrAlchemistLinked
+	errInvalidInvitation      = errors.New("invalid, expired or already used invitation")
+	errAccountDisabled        = errors.New("account disabled")
+	errCannotModifySelf       = errors.New("supervisors cannot demote or disable their own account")
+	errLastSupervisor         = errors.New("cannot demote or disable the last active supervisor")
+)
+
+// validRoles son los roles que puede tener un usuario.
+var validRoles = map[string]bool{
+	roleAlchemist:  true,
+	roleSupervisor: true,
+}
+
+type newUser struct {
+	Email       string
+	Password    string
+	Role        string
+	AlchemistID *uint
+}
+
+// validateNewUser normaliza los datos y comprueba email, rol y alquimista.
+func (s *Server) validateNewUser(u *newUser) error {
+	u.Email = strings.TrimSpace(u.Email)
+	u.Role = strings.ToUpper(strings.TrimSpace(u.Role))
+	if u.Email == "" || u.Password == "" {
+		return errInvalidUserInput
+	}
+	if !validRoles[u.Role] {
+		return errInvalidRole
+	}
+	if err := s.validatePassword(u.Password, u.Email); err != nil {
+		return err
+	}
+	exists, err := s.UserRepository.FindByEmail(u.Email)
+	if err != nil {
+		return err
+	}
+	if exists != nil {
+		return errEmailRegistered
+	}
+	return s.validateUserAlchemist(u.AlchemistID, 0)
+}
+
+// validateUserAlchemist exige que el alquimista exista y no esté vinculado a
+// otro usuario distinto de excludeUserID.
+func (s *Server) validateUserAlchemist(alchemistID *uint, excludeUserID uint) error {
+	if alchemistID == nil {
+		return nil
+	}
+	alchemist, err := s.AlchemistRepository.FindById(int(*alchemistID))
+	if err != nil {
+		return err
+	}
+	if alchemist == nil {
+		return errUnknownAlchemist
+	}
+	linked, err := s.UserRepository.FindByAlchemistID(*alchemistID)
+	if err != nil {
+		return err
+	}
+	if linked != nil && linked.ID != excludeUserID {
+		return errAlchemistLinked
+	}
+	return nil
+}
+
+// createUser guarda el usuario y, si viene de una invitación, la consume en la
+// misma transacción para que no pueda usarse dos veces.
+func (s *Server) createUser(u newUser, invitation *models.Invitation) (*models.User, error) {
+	if err := s.validateNewUser(&u); err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(u.Password)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Email:        u.Email,
+		PasswordHash: hash,
+		Role:         u.Role,
+		AlchemistID:  u.AlchemistID,
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := repository.NewUserRepository(tx).Save(user); err != nil {
+			return err
+		}
+		if invitation == nil {
+			return nil
+		}
+		consumed, err := repository.NewInvitationRepository(tx).Consume(invitation.ID, user.ID, time.Now())
+		if err != nil {
+			return err
+		}
+		if !consumed {
+			return errInvalidInvitation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// redeemInvitation valida el token de invitación y completa el rol (y el
+// alquimista, si la invitación lo fija) del usuario que se registra.
+func (s *Server) redeemInvitation(token string, u *newUser) (*models.Invitation, error) {
+	invitation, err := s.InvitationRepository.FindByHash(hashRefreshToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if invitation == nil || invitation.UsedAt != nil || !invitation.ExpiresAt.After(time.Now()) {
+		return nil, errInvalidInvitation
+	}
+	if invitation.Email != "" && !strings.EqualFold(invitation.Email, strings.TrimSpace(u.Email)) {
+		return nil, errInvalidInvitation
+	}
+	u.Role = invitation.Role
+	if invitation.AlchemistID != nil {
+		u.AlchemistID = invitation.AlchemistID
+	}
+	return invitation, nil
+}
+
+// bootstrapSupervisor crea el primer SUPERVISOR a partir de SUPERVISOR_EMAIL y
+// SUPERVISOR_PASSWORD si todavía no hay ninguno; el registro público ya no
+// permite crearlo.
+func (s *Server) bootstrapSupervisor() {
+	email := strings.TrimSpace(os.Getenv("SUPERVISOR_EMAIL"))
+	password := os.Getenv("SUPERVISOR_PASSWORD")
+	if email == "" || password == "" {
+		return
+	}
+	count, err := s.UserRepository.CountByRole(roleSupervisor)
+	if err != nil {
+		s.logger.Warn("no se pudo verificar si existe un supervisor", "error", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+	user, err := s.createUser(newUser{Email: email, Password: password, Role: roleSupervisor}, nil)
+	if err != nil {
+		s.logger.Warn("no se pudo crear el supervisor inicial", "error", err)
+		return
+	}
+	s.auditUser(systemActor, auditActionUserCreated, user.ID, fmt.Sprintf("Supervisor inicial %s creado desde el entorno", user.Email))
+	s.logger.Info("supervisor inicial creado", "email", user.Email)
+}
+
+// userChange es un cambio ya validado sobre un usuario, con su auditoría y la
+// columna que modifica.
+type userChange struct {
+	action      string
+	description string
+	column      string
+	value       interface{}
+}
+
+// applyUserPatch valida y aplica role/disabled/alchemist_id. actorID es el
+// supervisor que hace el cambio, que no puede degradarse ni deshabilitarse.
+func (s *Server) applyUserPatch(user *models.User, role *string, disabled *bool, alchemistID *uint, actorID uint) ([]userChange, error) {
+	var changes []userChange
+	// solo importa si el usuario cuenta hoy como supervisor activo
+	wasActiveSupervisor := user.Role == roleSupervisor && !user.Disabled
+	losesSupervisor := false
+
+	if role != nil {
+		newRole := strings.ToUpper(strings.TrimSpace(*role))
+		if !validRoles[newRole] {
+			return nil, errInvalidRole
+		}
+		if newRole != user.Role {
+			losesSupervisor = losesSupervisor || user.Role == roleSupervisor
+			changes = append(changes, userChange{auditActionUserRoleChanged,
+				fmt.Sprintf("Rol de %s cambiado de %s a %s", user.Email, user.Role, newRole), "role", newRole})
+			user.Role = newRole
+		}
+	}
+	if disabled != nil && *disabled != user.Disabled {
+		if *disabled {
+			losesSupervisor = losesSupervisor || user.Role == roleSupervisor
+			changes = append(changes, userChange{auditActionUserDisabled, fmt.Sprintf("Usuario %s deshabilitado", user.Email), "disabled", true})
+		} else {
+			changes = append(changes, userChange{auditActionUserEnabled, fmt.Sprintf("Usuario %s habilitado", user.Email), "disabled", false})
+		}
+		user.Disabled = *disabled
+	}
+	if alchemistID != nil {
+		var next *uint
+		if *alchemistID > 0 {
+			next = alchemistID
+		}
+		if !sameAlchemist(user.AlchemistID, next) {
+			if err := s.validateUserAlchemist(next, user.ID); err != nil {
+				return nil, err
+			}
+			description := fmt.Sprintf("Usuario %s desvinculado de su alquimista", user.Email)
+			if next != nil {
+				description = fmt.Sprintf("Usuario %s vinculado al alquimista #%d", user.Email, *next)
+			}
+			changes = append(changes, userChange{auditActionUserAlchemistLinked, description, "alchemist_id", next})
+			user.AlchemistID = next
+		}
+	}
+
+	if wasActiveSupervisor && losesSupervisor {
+		if user.ID == actorID {
+			return nil, errCannotModifySelf
+		}
+		// user todavía cuenta como supervisor activo en la base de datos
+		count, err := s.UserRepository.CountByRole(roleSupervisor)
+		if err != nil {
+			return nil, err
+		}
+		if count <= 1 {
+			return nil, errLastSupervisor
+		}
+	}
+	return changes, nil
+}
+
+func sameAlchemist(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// saveUserChanges guarda solo las columnas cambiadas, cierra las sesiones del
+// usuario (los tokens llevan rol y alquimista) y audita cada cambio.
+func (s *Server) saveUserChanges(actor auditActor, user *models.User, changes []userChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(changes))
+	for _, c := range changes {
+		fields[c.column] = c.value
+	}
+	if err := s.UserRepository.UpdateFields(user.ID, fields); err != nil {
+		return err
+	}
+	if err := s.TokenRepository.RevokeUser(user.ID, time.Now()); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		s.auditUser(actor, c.action, user.ID, c.description)
+	}
+	return nil
+}
+
+func (s *Server) auditUser(actor auditActor, action string, userID uint, description string) {
+	if err := s.saveAudit(actor, &models.Audit{
+		Action:      action,
+		Entity:      auditEntityUser,
+		EntityID:    userID,
+		Description: description,
+	}); err != nil {
+		s.logger.Warn("no se pudo auditar el usuario", "user_id", userID, "error", err)
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"backend-avanzada/api"
+	"backend-avanzada/models"
+	"backend-avanzada/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditActionWebhookCreated = "WEBHOOK_CREATED"
+	auditActionWebhookUpdated = "WEBHOOK_UPDATED"
+	auditActionWebhookDeleted = "WEBHOOK_DELETED"
+	auditEntityWebhook        = "webhook"
+)
+
+var (
+	errInvalidWebhookURL = errors.New("webhook url must be an absolute http(s) url")
+	errWebhookNoEvents   = errors.New("webhook requires at least one event")
+)
+
+var webhookSortFields = map[string]string{
+	"id":         "id",
+	"url":        "url",
+	"created_at": "created_at",
+}
+
+var webhookDeliverySortFields = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+}
+
+func (s *Server) HandleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		spec, pageReq, err := parseListQuery(r.URL.Query(), webhookSortFields)
+		if err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		page, err := s.WebhookRepository.FindPage(spec)
+		if err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		resp := []*api.WebhookResponseDto{}
+		var lastID uint
+		for _, hook := range page.Items {
+			resp = append(resp, hook.ToResponseDto())
+			lastID = hook.ID
+		}
+		writePageHeaders(w, pageReq, page.Total, page.HasMore, lastID)
+		json.NewEncoder(w).Encode(resp)
+		return
+
+	case http.MethodPost:
+		var req api.WebhookRequestDto
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		hook := &models.Webhook{Active: true, Secret: strings.TrimSpace(req.Secreto)}
+		if err := applyWebhookRequest(hook, &req); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		if hook.Secret == "" {
+			secret, err := generateWebhookSecret()
+			if err != nil {
+				s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+				return
+			}
+			hook.Secret = secret
+		}
+		if _, err := s.WebhookRepository.Save(hook); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		s.auditWebhook(r, auditActionWebhookCreated, hook, fmt.Sprintf("Webhook #%d creado hacia %s (%s)", hook.ID, hook.URL, hook.Events))
+		resp := hook.ToResponseDto()
+		// el secreto solo se muestra una vez, al crearlo
+		resp.Secreto = hook.Secret
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+}
+
+func (s *Server) HandleWebhooksWithId(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.findWebhook(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(hook.ToResponseDto())
+		return
+
+	case http.MethodPut:
+		var req api.WebhookRequestDto
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		if err := applyWebhookRequest(hook, &req); err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+			return
+		}
+		if secret := strings.TrimSpace(req.Secreto); secret != "" {
+			hook.Secret = secret
+		}
+		if _, err := s.WebhookRepository.Save(hook); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		s.auditWebhook(r, auditActionWebhookUpdated, hook, fmt.Sprintf("Webhook #%d actualizado: %s (%s), activo=%t", hook.ID, hook.URL, hook.Events, hook.Active))
+		json.NewEncoder(w).Encode(hook.ToResponseDto())
+		return
+
+	case http.MethodDelete:
+		if err := s.WebhookRepository.Delete(hook); err != nil {
+			s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+			return
+		}
+		s.auditWebhook(r, auditActionWebhookDeleted, hook, fmt.Sprintf("Webhook #%d hacia %s eliminado", hook.ID, hook.URL))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
+
+// HandleWebhookTest envía un evento webhook:test de forma síncrona y devuelve
+// el intento registrado, sin reintentos.
+func (s *Server) HandleWebhookTest(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.findWebhook(w, r)
+	if !ok {
+		return
+	}
+	body, err := json.Marshal(wsEnvelope{
+		Type:      "webhook:test",
+		Timestamp: time.Now().UTC(),
+		Data:      map[string]interface{}{"webhook_id": hook.ID, "message": "Prueba de entrega"},
+	})
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	delivery := s.deliverWebhook(&webhookJob{
+		hook:       hook,
+		deliveryID: fmt.Sprintf("test-%d-%d", hook.ID, time.Now().UnixNano()),
+		event:      "webhook:test",
+		body:       body,
+		attempt:    1,
+		noRetry:    true,
+	})
+	json.NewEncoder(w).Encode(delivery.ToResponseDto())
+}
+
+// HandleWebhookDeliveries lista el log de entregas de un webhook, más recientes primero.
+func (s *Server) HandleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.findWebhook(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	spec, pageReq, err := parseListQuery(q, webhookDeliverySortFields, repository.SortField{Column: "id", Desc: true})
+	if err != nil {
+		s.HandleError(w, http.StatusBadRequest, r.URL.Path, err)
+		return
+	}
+	spec.Filters = append(spec.Filters, repository.Filter{Column: "webhook_id", Op: repository.OpEq, Value: hook.ID})
+	spec.Filters = append(spec.Filters, filterIfPresent(q, "event", "event")...)
+	if v := strings.TrimSpace(q.Get("success")); v != "" {
+		success, err := strconv.ParseBool(v)
+		if err != nil {
+			s.HandleError(w, http.StatusBadRequest, r.URL.Path, fmt.Errorf("invalid success %q", v))
+			return
+		}
+		spec.Filters = append(spec.Filters, repository.Filter{Column: "success", Op: repository.OpEq, Value: success})
+	}
+	page, err := s.WebhookDeliveryRepository.FindPage(spec)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return
+	}
+	resp := []*api.WebhookDeliveryResponseDto{}
+	var lastID uint
+	for _, d := range page.Items {
+		resp = append(resp, d.ToResponseDto())
+		lastID = d.ID
+	}
+	writePageHeaders(w, pageReq, page.Total, page.HasMore, lastID)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) findWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	hook, err := s.WebhookRepository.FindById(id)
+	if err != nil {
+		s.HandleError(w, http.StatusInternalServerError, r.URL.Path, err)
+		return nil, false
+	}
+	if hook == nil {
+		s.HandleError(w, http.StatusNotFound, r.URL.Path, fmt.Errorf("webhook %d not found", id))
+		return nil, false
+	}
+	return hook, true
+}
+
+func applyWebhookRequest(hook *models.Webhook, req *api.WebhookRequestDto) error {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errInvalidWebhookURL
+	}
+	var events []string
+	for _, e := range req.Eventos {
+		e = strings.TrimSpace(e)
+		if err := validateTopic(e); err != nil {
+			return err
+		}
+		events = append(events, e)
+	}
+	if len(events) == 0 {
+		return errWebhookNoEvents
+	}
+	hook.URL = u.String()
+	hook.Events = strings.Join(events, ",")
+	if req.Activo != nil {
+		hook.Active = *req.Activo
+	}
+	return nil
+}
+
+func (s *Server) auditWebhook(r *http.Request, action string, hook *models.Webhook, description string) {
+	if err := s.saveAudit(actorFromRequest(r), &models.Audit{
+		Action:      action,
+		Entity:      auditEntityWebhook,
+		EntityID:    hook.ID,
+		Description: description,
+	}); err != nil {
+		s.logger.Warn("no se pudo auditar el webhook", "webhook_id", hook.ID, "error", err)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"backend-avanzada/models"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookWorkers     = 4
+	webhookQueueSize   = 256
+	webhookMaxAttempts = 5
+	webhookBaseBackoff = 2 * time.Second
+	webhookMaxBackoff  = 5 * time.Minute
+	webhookTimeout     = 10 * time.Second
+	// cuánto del cuerpo de la respuesta se guarda en el log ante un error
+	webhookErrorBodyLimit = 512
+)
+
+// webhookJob es un intento de entrega pendiente. body es el mismo sobre JSON
+// que reciben /ws y /events, así todos los transportes comparten formato.
+type webhookJob struct {
+	hook       *models.Webhook
+	deliveryID string
+	event      string
+	body       []byte
+	attempt    int
+	// retryOf es el intento fallido que este reintento reemplaza
+	retryOf uint
+	// noRetry deja un fallo como definitivo (la prueba manual no reintenta)
+	noRetry bool
+}
+
+// startWebhooks engancha el despacho al hub: cada evento ya secuenciado pasa
+// por dispatchWebhooks sin bloquear Run.
+func (s *Server) startWebhooks() {
+	s.webhookEvents = make(chan *hubEvent, webhookQueueSize)
+	s.webhookJobs = make(chan *webhookJob, webhookQueueSize)
+	s.webhookClient = &http.Client{Timeout: webhookTimeout}
+	s.WsHub.onPublish = func(ev *hubEvent) {
+		select {
+		case s.webhookEvents <- ev:
+		default:
+			s.logger.Warn("cola de webhooks llena, se descarta el evento", "event", ev.Type, "seq", ev.Seq)
+		}
+	}
+	go s.dispatchWebhooks()
+	for i := 0; i < webhookWorkers; i++ {
+		go s.webhookWorker()
+	}
+	if err := s.resumeWebhookDeliveries(); err != nil {
+		s.logger.Error("no se pudieron reprogramar los reintentos de webhooks", "error", err)
+	}
+}
+
+// resumeWebhookDeliveries reprograma los reintentos que quedaron pendientes en
+// la base al apagarse el servidor; los vencidos salen de inmediato.
+func (s *Server) resumeWebhookDeliveries() error {
+	pending, err := s.WebhookDeliveryRepository.FindPendingRetries()
+	if err != nil {
+		return err
+	}
+	resumed := 0
+	for _, d := range pending {
+		hook, err := s.webhookRetryTarget(d)
+		if err != nil {
+			return err
+		}
+		if hook == nil {
+			continue
+		}
+		s.scheduleWebhookRetry(&webhookJob{
+			hook:       hook,
+			deliveryID: d.DeliveryID,
+			event:      d.Event,
+			body:       []byte(d.Payload),
+		}, d)
+		resumed++
+	}
+	if resumed > 0 {
+		s.logger.Info("reintentos de webhooks reprogramados", "count", resumed)
+	}
+	return nil
+}
+
+func (s *Server) dispatchWebhooks() {
+	for ev := range s.webhookEvents {
+		hooks, err := s.WebhookRepository.FindActive()
+		if err != nil {
+			s.logger.Warn("no se pudieron cargar los webhooks", "error", err)
+			continue
+		}
+		for _, hook := range hooks {
+			if !webhookMatches(hook, ev) {
+				continue
+			}
+			s.enqueueWebhook(&webhookJob{
+				hook:       hook,
+				deliveryID: fmt.Sprintf("%d-%d-%d", ev.Seq, hook.ID, time.Now().UnixNano()),
+				event:      ev.Type,
+				body:       ev.Payload,
+				attempt:    1,
+			})
+		}
+	}
+}
+
+func webhookMatches(hook *models.Webhook, ev *hubEvent) bool {
+	for _, topic := range hook.EventList() {
+		if topicMatches(topic, ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) enqueueWebhook(job *webhookJob) {
+	select {
+	case s.webhookJobs <- job:
+	default:
+		s.logger.Warn("cola de webhooks llena, se descarta la entrega", "delivery_id", job.deliveryID, "url", job.hook.URL)
+	}
+}
+
+func (s *Server) webhookWorker() {
+	for job := range s.webhookJobs {
+		delivery := s.deliverWebhook(job)
+		if delivery.NextAttemptAt == nil {
+			if !delivery.Success {
+				s.logger.Warn("entrega de webhook abandonada", "webhook_id", job.hook.ID, "delivery_id", job.deliveryID, "attempts", job.attempt)
+			}
+			continue
+		}
+		s.scheduleWebhookRetry(job, delivery)
+	}
+}
+
+// scheduleWebhookRetry encola el siguiente intento de failed a la hora que
+// quedó guardada en NextAttemptAt. El webhook se recarga en ese momento: entre
+// intentos pudo editarse (URL, secreto), desactivarse o borrarse.
+func (s *Server) scheduleWebhookRetry(job *webhookJob, failed *models.WebhookDelivery) {
+	next := *job
+	next.attempt = failed.Attempt + 1
+	next.retryOf = failed.ID
+	time.AfterFunc(time.Until(*failed.NextAttemptAt), func() {
+		hook, err := s.webhookRetryTarget(failed)
+		if err != nil {
+			// sigue pendiente: se retoma en el próximo arranque
+			s.logger.Warn("no se pudo recargar el webhook del reintento", "webhook_id", failed.WebhookID, "delivery_id", failed.DeliveryID, "error", err)
+			return
+		}
+		if hook == nil {
+			return
+		}
+		next.hook = hook
+		s.enqueueWebhook(&next)
+	})
+}
+
+// webhookRetryTarget recarga el webhook de una entrega pendiente. Si se borró o
+// se desactivó descarta el reintento y devuelve nil: no queda a quién reintentar.
+func (s *Server) webhookRetryTarget(pending *models.WebhookDelivery) (*models.Webhook, error) {
+	hook, err := s.WebhookRepository.FindById(int(pending.WebhookID))
+	if err != nil {
+		return nil, err
+	}
+	if hook == nil || !hook.Active {
+		return nil, s.WebhookDeliveryRepository.ClearNextAttempt(pending.ID)
+	}
+	return hook, nil
+}
+
+// webhookBackoff duplica la espera en cada intento: 2s, 4s, 8s... hasta webhookMaxBackoff.
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookBaseBackoff << (attempt - 1)
+	if d <= 0 || d > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return d
+}
+
+// deliverWebhook hace un intento de entrega y lo registra en el log.
+//
+// La firma va en X-Webhook-Signature como "sha256=<hex>" y es el HMAC-SHA256,
+// con el secreto del webhook, de "<X-Webhook-Timestamp>.<cuerpo>".
+func (s *Server) deliverWebhook(job *webhookJob) *models.WebhookDelivery {
+	delivery := &models.WebhookDelivery{
+		WebhookID:  job.hook.ID,
+		DeliveryID: job.deliveryID,
+		Event:      job.event,
+		Payload:    string(job.body),
+		Attempt:    job.attempt,
+	}
+	start := time.Now()
+	err := func() error {
+		req, err := http.NewRequest(http.MethodPost, job.hook.URL, bytes.NewReader(job.body))
+		if err != nil {
+			return err
+		}
+		ts := strconv.FormatInt(start.Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "amestris-webhooks/1.0")
+		req.Header.Set("X-Webhook-Event", job.event)
+		req.Header.Set("X-Webhook-Delivery", job.deliveryID)
+		req.Header.Set("X-Webhook-Attempt", strconv.Itoa(job.attempt))
+		req.Header.Set("X-Webhook-Timestamp", ts)
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(job.hook.Secret, ts, job.body))
+
+		resp, err := s.webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		delivery.StatusCode = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorBodyLimit))
+			return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}()
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.Success = err == nil
+	if err != nil {
+		delivery.Error = err.Error()
+		if !job.noRetry && job.attempt < webhookMaxAttempts {
+			next := time.Now().Add(webhookBackoff(job.attempt))
+			delivery.NextAttemptAt = &next
+		}
+	}
+	if _, saveErr := s.WebhookDeliveryRepository.Save(delivery); saveErr != nil {
+		s.logger.Warn("no se pudo registrar la entrega", "delivery_id", job.deliveryID, "error", saveErr)
+		return delivery
+	}
+	// el intento anterior deja de estar pendiente recién cuando este quedó guardado
+	if job.retryOf != 0 {
+		if err := s.WebhookDeliveryRepository.ClearNextAttempt(job.retryOf); err != nil {
+			s.logger.Warn("no se pudo cerrar el reintento anterior", "delivery_id", job.deliveryID, "error", err)
+		}
+	}
+	return delivery
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
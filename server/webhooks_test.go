@@ -0,0 +1,325 @@
+package server
+
+import (
+	"backend-avanzada/logger"
+	"backend-avanzada/models"
+	"backend-avanzada/repository"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+const testWebhookSecret = "s3cr3t"
+
+// webhookReceiver verifica la firma de cada entrega y responde 500 al primer
+// intento y 204 a los siguientes.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	delivery    string
+	attempt     string
+	signatureOK bool
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(r.Header.Get("X-Webhook-Timestamp") + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	attempt := r.Header.Get("X-Webhook-Attempt")
+	rcv.mu.Lock()
+	rcv.requests = append(rcv.requests, receivedWebhook{
+		delivery:    r.Header.Get("X-Webhook-Delivery"),
+		attempt:     attempt,
+		signatureOK: hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(want)),
+	})
+	rcv.mu.Unlock()
+	if attempt == "1" {
+		http.Error(w, "boom", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rcv *webhookReceiver) received() []receivedWebhook {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]receivedWebhook(nil), rcv.requests...)
+}
+
+// openTestDB abre una base sqlite en un directorio temporal con las tablas de models.
+func openTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newWebhookTestServer(t *testing.T, client *http.Client) *Server {
+	t.Helper()
+	db := openTestDB(t, &models.Webhook{}, &models.WebhookDelivery{})
+	return &Server{
+		DB:                        db,
+		WebhookRepository:         repository.NewWebhookRepository(db),
+		WebhookDeliveryRepository: repository.NewWebhookDeliveryRepository(db),
+		logger:                    logger.New("text", "error", io.Discard),
+		webhookClient:             client,
+	}
+}
+
+func createTestWebhook(t *testing.T, s *Server, url string, active bool) *models.Webhook {
+	t.Helper()
+	hook := &models.Webhook{URL: url, Events: "transmutation:*", Secret: testWebhookSecret, Active: active}
+	if _, err := s.WebhookRepository.Save(hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func webhookDeliveries(t *testing.T, s *Server, hookID uint) []*models.WebhookDelivery {
+	t.Helper()
+	var rows []*models.WebhookDelivery
+	if err := s.DB.Where("webhook_id = ?", hookID).Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestWebhookRetriesAfterFailureAndRestart(t *testing.T) {
+	rcv := &webhookReceiver{}
+	receiver := httptest.NewServer(rcv)
+	defer receiver.Close()
+
+	s := newWebhookTestServer(t, receiver.Client())
+	hook := createTestWebhook(t, s, receiver.URL, true)
+
+	before := time.Now()
+	first := s.deliverWebhook(&webhookJob{
+		hook:       hook,
+		deliveryID: "d-1",
+		event:      "transmutation:completed",
+		body:       []byte(`{"type":"transmutation:completed"}`),
+		attempt:    1,
+	})
+	if first.Success || first.StatusCode != http.StatusInternalServerError || !strings.Contains(first.Error, "boom") {
+		t.Fatalf("primer intento = success %v, status %d, error %q", first.Success, first.StatusCode, first.Error)
+	}
+	if first.NextAttemptAt == nil {
+		t.Fatal("el intento fallido no guardó NextAttemptAt")
+	}
+	if wait := first.NextAttemptAt.Sub(before); wait < webhookBaseBackoff || wait > webhookBaseBackoff+time.Second {
+		t.Errorf("NextAttemptAt a %v del intento, want ~%v", wait, webhookBaseBackoff)
+	}
+
+	// simula un reinicio con el reintento ya vencido: lo retoma resumeWebhookDeliveries
+	if err := s.DB.Model(first).UpdateColumn("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.webhookJobs = make(chan *webhookJob, 1)
+	defer close(s.webhookJobs)
+	go s.webhookWorker()
+	if err := s.resumeWebhookDeliveries(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []*models.WebhookDelivery
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rows = webhookDeliveries(t, s, hook.ID)
+		if len(rows) == 2 && rows[0].NextAttemptAt == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("el reintento no quedó registrado: %d entregas", len(rows))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	retry := rows[1]
+	if rows[0].Attempt != 1 || rows[0].Success || rows[0].StatusCode != http.StatusInternalServerError {
+		t.Errorf("entrega 1 = attempt %d, success %v, status %d", rows[0].Attempt, rows[0].Success, rows[0].StatusCode)
+	}
+	if retry.Attempt != 2 || !retry.Success || retry.StatusCode != http.StatusNoContent || retry.NextAttemptAt != nil {
+		t.Errorf("entrega 2 = attempt %d, success %v, status %d, next %v", retry.Attempt, retry.Success, retry.StatusCode, retry.NextAttemptAt)
+	}
+	if retry.DeliveryID != "d-1" || retry.Event != "transmutation:completed" {
+		t.Errorf("entrega 2 = delivery %q, event %q", retry.DeliveryID, retry.Event)
+	}
+
+	got := rcv.received()
+	if len(got) != 2 {
+		t.Fatalf("el receptor recibió %d requests, want 2", len(got))
+	}
+	for i, req := range got {
+		if !req.signatureOK {
+			t.Errorf("request %d: firma X-Webhook-Signature inválida", i+1)
+		}
+		if req.delivery != "d-1" {
+			t.Errorf("request %d: X-Webhook-Delivery = %q", i+1, req.delivery)
+		}
+	}
+}
+
+func TestWebhookDeliveryWithoutRetry(t *testing.T) {
+	s := newWebhookTestServer(t, http.DefaultClient)
+	// nadie escucha en el puerto 1: todas las entregas fallan
+	hook := createTestWebhook(t, s, "http://127.0.0.1:1", true)
+
+	cases := []struct {
+		name string
+		job  *webhookJob
+	}{
+		{"prueba manual", &webhookJob{hook: hook, deliveryID: "test-1", event: "webhook:test", body: []byte(`{}`), attempt: 1, noRetry: true}},
+		{"último intento", &webhookJob{hook: hook, deliveryID: "d-2", event: "transmutation:failed", body: []byte(`{}`), attempt: webhookMaxAttempts}},
+	}
+	for _, tc := range cases {
+		d := s.deliverWebhook(tc.job)
+		if d.Success || d.Error == "" {
+			t.Errorf("%s: success %v, error %q; want un fallo", tc.name, d.Success, d.Error)
+		}
+		if d.NextAttemptAt != nil {
+			t.Errorf("%s: NextAttemptAt = %v, want nil", tc.name, d.NextAttemptAt)
+		}
+	}
+
+	pending, err := s.WebhookDeliveryRepository.FindPendingRetries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("quedaron %d reintentos pendientes, want 0", len(pending))
+	}
+}
+
+func TestResumeWebhookDeliveriesSkipsInactiveHooks(t *testing.T) {
+	s := newWebhookTestServer(t, http.DefaultClient)
+	hook := createTestWebhook(t, s, "http://127.0.0.1:1", false)
+
+	next := time.Now().Add(time.Hour)
+	d := &models.WebhookDelivery{WebhookID: hook.ID, DeliveryID: "d-3", Event: "transmutation:completed", Attempt: 1, NextAttemptAt: &next}
+	if _, err := s.WebhookDeliveryRepository.Save(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.resumeWebhookDeliveries(); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := s.WebhookDeliveryRepository.FindPendingRetries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("el reintento de un webhook inactivo siguió pendiente")
+	}
+}
+
+func TestWebhookRetryReloadsHook(t *testing.T) {
+	cases := []struct {
+		name      string
+		change    func(t *testing.T, s *Server, hook *models.Webhook, newURL string)
+		wantMoved bool
+	}{
+		{
+			name: "webhook desactivado",
+			change: func(t *testing.T, s *Server, hook *models.Webhook, newURL string) {
+				if err := s.DB.Model(hook).Update("active", false).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "webhook borrado",
+			change: func(t *testing.T, s *Server, hook *models.Webhook, newURL string) {
+				if err := s.WebhookRepository.Delete(hook); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "URL editada",
+			change: func(t *testing.T, s *Server, hook *models.Webhook, newURL string) {
+				if err := s.DB.Model(hook).Update("url", newURL).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMoved: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			oldRcv, newRcv := &webhookReceiver{}, &webhookReceiver{}
+			oldSrv, newSrv := httptest.NewServer(oldRcv), httptest.NewServer(newRcv)
+			defer oldSrv.Close()
+			defer newSrv.Close()
+
+			s := newWebhookTestServer(t, http.DefaultClient)
+			hook := createTestWebhook(t, s, oldSrv.URL, true)
+			job := &webhookJob{hook: hook, deliveryID: "d-4", event: "transmutation:completed", body: []byte(`{}`), attempt: 1}
+			first := s.deliverWebhook(job)
+			if first.NextAttemptAt == nil {
+				t.Fatal("el intento fallido no guardó NextAttemptAt")
+			}
+
+			stale := *hook
+			tc.change(t, s, hook, newSrv.URL)
+			s.webhookJobs = make(chan *webhookJob, 1)
+			defer close(s.webhookJobs)
+			go s.webhookWorker()
+			now := time.Now()
+			first.NextAttemptAt = &now
+			job.hook = &stale
+			s.scheduleWebhookRetry(job, first)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				pending, err := s.WebhookDeliveryRepository.FindPendingRetries()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(pending) == 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("el reintento siguió pendiente")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if got := len(oldRcv.received()); got != 1 {
+				t.Errorf("la URL vieja recibió %d requests, want 1", got)
+			}
+			wantNew := 0
+			if tc.wantMoved {
+				wantNew = 1
+			}
+			if got := len(newRcv.received()); got != wantNew {
+				t.Errorf("la URL nueva recibió %d requests, want %d", got, wantNew)
+			}
+		})
+	}
+}
@@ -2,92 +2,442 @@
 package server
 
 import (
+	"backend-avanzada/api"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	wsAuthTimeout = 10 * time.Second
+	// eventos que guarda el hub para reenviar tras una reconexión; debe caber en el buffer de send
+	wsReplayBufferSize = 200
+	wsTopicAll         = "*"
+	wsTopicPrefixA     = "alchemist:"
+)
+
+var errInvalidTopic = errors.New("invalid topic")
+
 type Hub struct {
 	mu         sync.RWMutex
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan *hubEvent
 	register   chan *Client
 	unregister chan *Client
+	replay     chan replayRequest
+
+	// onPublish recibe cada evento ya secuenciado (p. ej. webhooks); no debe bloquear
+	onPublish func(*hubEvent)
+
+	// mensajes descartados por tener el buffer del cliente lleno
+	dropped atomic.Uint64
+	// true mientras corre Run (ver /readyz)
+	running atomic.Bool
+
+	// seq y history solo los toca Run; history es un buffer circular
+	seq     uint64
+	history []*hubEvent
+	next    int
+}
+
+// hubEvent es un evento a publicar junto con los datos para filtrarlo. Run le
+// asigna Seq y arma Payload, el sobre ya serializado.
+type hubEvent struct {
+	Seq         uint64
+	Type        string
+	AlchemistID *uint
+	Data        json.RawMessage
+	Payload     []byte
+}
+
+// replayRequest pide reenviar a un cliente ya registrado los eventos posteriores a From.
+type replayRequest struct {
+	client *Client
+	from   uint64
 }
 
 func NewHub() *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
+		broadcast:  make(chan *hubEvent, 256),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		replay:     make(chan replayRequest),
+		history:    make([]*hubEvent, 0, wsReplayBufferSize),
 	}
 }
 
 func (h *Hub) Run() {
+	h.running.Store(true)
+	defer h.running.Store(false)
 	for {
 		select {
 		case c := <-h.register:
 			h.mu.Lock()
 			h.clients[c] = true
 			h.mu.Unlock()
+			// el replay corre en el mismo goroutine que los broadcasts: no hay huecos ni duplicados
+			if c.resumeFrom > 0 {
+				h.replayTo(c, c.resumeFrom)
+			}
+
+		case req := <-h.replay:
+			h.mu.RLock()
+			_, ok := h.clients[req.client]
+			h.mu.RUnlock()
+			if ok {
+				h.replayTo(req.client, req.from)
+			}
 
 		case c := <-h.unregister:
 			h.mu.Lock()
 			if _, ok := h.clients[c]; ok {
 				delete(h.clients, c)
-				close(c.send)
+				c.closeSend()
 			}
 			h.mu.Unlock()
 
-		case msg := <-h.broadcast:
-			h.mu.RLock()
-			for c := range h.clients {
-				select {
-				case c.send <- msg:
-				default:
+		case ev := <-h.broadcast:
+			h.seq++
+			ev.Seq = h.seq
+			b, err := json.Marshal(wsEnvelope{Seq: ev.Seq, Type: ev.Type, Timestamp: time.Now().UTC(), Data: ev.Data})
+			if err != nil {
+				continue
+			}
+			ev.Payload = b
+			ev.Data = nil
+			h.remember(ev)
+			if h.onPublish != nil {
+				h.onPublish(ev)
+			}
 
-					close(c.send)
+			h.mu.Lock()
+			for c := range h.clients {
+				if !c.accepts(ev) {
+					continue
+				}
+				if !c.trySend(ev) {
+					c.closeSend()
 					delete(h.clients, c)
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
+		}
+	}
+}
+
+// ClientCounts cuenta los clientes registrados por transporte ("ws" o "sse").
+func (h *Hub) ClientCounts() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	counts := map[string]int{"ws": 0, "sse": 0}
+	for c := range h.clients {
+		if c.conn == nil {
+			counts["sse"]++
+		} else {
+			counts["ws"]++
+		}
+	}
+	return counts
+}
+
+// Running indica si el goroutine de Run está activo.
+func (h *Hub) Running() bool {
+	return h.running.Load()
+}
+
+// Dropped devuelve cuántos mensajes se descartaron desde el arranque.
+func (h *Hub) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+func (h *Hub) remember(ev *hubEvent) {
+	if len(h.history) < wsReplayBufferSize {
+		h.history = append(h.history, ev)
+		return
+	}
+	h.history[h.next] = ev
+	h.next = (h.next + 1) % wsReplayBufferSize
+}
+
+// replayTo reenvía en orden los eventos con seq > from que el cliente acepta.
+// Si from ya salió del buffer, o es de un arranque anterior del servidor, se
+// avisa con replay:gap para que el cliente recargue el estado completo.
+func (h *Hub) replayTo(c *Client, from uint64) {
+	if from == h.seq {
+		return
+	}
+	n := len(h.history)
+	oldest := h.seq + 1
+	if n > 0 {
+		oldest = h.history[h.next%n].Seq
+	}
+	if from > h.seq || oldest > from+1 {
+		c.reply("replay:gap", map[string]uint64{"resume_from": from, "oldest_seq": oldest, "last_seq": h.seq})
+		if from > h.seq {
+			from = 0
+		}
+	}
+	for i := 0; i < n; i++ {
+		ev := h.history[(h.next+i)%n]
+		if ev.Seq <= from || !c.accepts(ev) {
+			continue
+		}
+		if !c.trySend(ev) {
+			h.mu.Lock()
+			delete(h.clients, c)
+			h.mu.Unlock()
+			c.closeSend()
+			return
 		}
 	}
 }
 
 type Client struct {
-	hub  *Hub
+	hub *Hub
+	// conn es nil para los suscriptores SSE, que leen send desde HandleEvents
 	conn *websocket.Conn
-	send chan []byte
+	send chan *hubEvent
+
+	// Datos del usuario autenticado; solo readPump los escribe antes de registrarse
+	role        string
+	alchemistID uint
+	registered  bool
+	resumeFrom  uint64
+	// session la fija quien autentica (HandleWS o readPump) y la lee writePump
+	session atomic.Pointer[streamSession]
+
+	mu     sync.RWMutex
+	topics map[string]bool
+
+	// sendMu protege send: el hub puede cerrarlo mientras readPump responde
+	sendMu     sync.Mutex
+	sendClosed bool
+}
+
+// trySend encola sin bloquear; devuelve false si el buffer está lleno.
+func (c *Client) trySend(ev *hubEvent) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return true
+	}
+	select {
+	case c.send <- ev:
+		return true
+	default:
+		c.hub.dropped.Add(1)
+		return false
+	}
+}
+
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.send)
+	}
+}
+
+func newClient(hub *Hub, conn *websocket.Conn) *Client {
+	return &Client{
+		hub:    hub,
+		conn:   conn,
+		send:   make(chan *hubEvent, 256),
+		topics: map[string]bool{wsTopicAll: true},
+	}
 }
 
-func (c *Client) readPump() {
+// wsClientMessage son los mensajes que acepta el socket:
+//
+//	{"type":"auth","token":"<jwt>","resume_from":42}
+//	{"type":"subscribe","topics":["transmutation:*","alchemist:3"]}
+//	{"type":"unsubscribe","topics":["mission:*"]}
+//	{"type":"resume","resume_from":42}
+type wsClientMessage struct {
+	Type       string   `json:"type"`
+	Token      string   `json:"token,omitempty"`
+	Topics     []string `json:"topics,omitempty"`
+	ResumeFrom uint64   `json:"resume_from,omitempty"`
+}
+
+func (c *Client) authenticate(token string, claims *jwtClaims) {
+	c.session.Store(newStreamSession(token, claims))
+	c.role = claims.Role
+	if claims.AlchemistID != nil {
+		c.alchemistID = *claims.AlchemistID
+	}
+}
+
+// accepts decide si el evento le llega al cliente: debe coincidir con algún
+// tópico suscrito y un ALCHEMIST solo ve las transmutaciones propias.
+func (c *Client) accepts(ev *hubEvent) bool {
+	if c.role == roleAlchemist && strings.HasPrefix(ev.Type, "transmutation:") {
+		if ev.AlchemistID == nil || *ev.AlchemistID != c.alchemistID {
+			return false
+		}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for topic := range c.topics {
+		if topicMatches(topic, ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func topicMatches(topic string, ev *hubEvent) bool {
+	switch {
+	case topic == wsTopicAll:
+		return true
+	case strings.HasPrefix(topic, wsTopicPrefixA):
+		id, _ := strconv.ParseUint(strings.TrimPrefix(topic, wsTopicPrefixA), 10, 64)
+		return ev.AlchemistID != nil && uint64(*ev.AlchemistID) == id
+	case strings.HasSuffix(topic, ":*"):
+		return strings.HasPrefix(ev.Type, strings.TrimSuffix(topic, "*"))
+	default:
+		return topic == ev.Type
+	}
+}
+
+func validateTopic(topic string) error {
+	switch {
+	case topic == "":
+		return errInvalidTopic
+	case strings.HasPrefix(topic, wsTopicPrefixA):
+		if _, err := strconv.ParseUint(strings.TrimPrefix(topic, wsTopicPrefixA), 10, 64); err != nil {
+			return fmt.Errorf("%w: %s", errInvalidTopic, topic)
+		}
+	}
+	return nil
+}
+
+func (c *Client) setTopics(topics []string, subscribe bool) error {
+	for _, t := range topics {
+		if err := validateTopic(strings.TrimSpace(t)); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range topics {
+		t = strings.TrimSpace(t)
+		if subscribe {
+			// al elegir tópicos concretos se deja la suscripción por defecto a todo
+			delete(c.topics, wsTopicAll)
+			c.topics[t] = true
+		} else {
+			delete(c.topics, t)
+		}
+	}
+	return nil
+}
+
+func (c *Client) currentTopics() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	topics := make([]string, 0, len(c.topics))
+	for t := range c.topics {
+		topics = append(topics, t)
+	}
+	return topics
+}
+
+// reply envía un mensaje solo a este cliente; si el buffer está lleno se descarta.
+func (c *Client) reply(eventType string, data interface{}) {
+	b, err := json.Marshal(wsEnvelope{Type: eventType, Timestamp: time.Now().UTC(), Data: data})
+	if err != nil {
+		return
+	}
+	_ = c.trySend(&hubEvent{Type: eventType, Payload: b})
+}
+
+func (c *Client) readPump(s *Server) {
+	// al cerrar send, writePump vacía lo pendiente (p. ej. el error de auth) y cierra la conexión
 	defer func() {
-		c.hub.unregister <- c
-		_ = c.conn.Close()
+		if c.registered {
+			c.hub.unregister <- c
+		} else {
+			c.closeSend()
+		}
 	}()
 	c.conn.SetReadLimit(1024)
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	if c.registered {
+		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	} else {
+		c.conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	}
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		return nil
 	})
 	for {
-
-		if _, _, err := c.conn.ReadMessage(); err != nil {
+		_, raw, err := c.conn.ReadMessage()
+		if err != nil {
 			break
 		}
+		var msg wsClientMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			c.reply("error", map[string]string{"message": "invalid message"})
+			continue
+		}
+
+		if !c.registered {
+			// el primer mensaje debe autenticar la conexión
+			if msg.Type != "auth" {
+				c.reply("error", map[string]string{"message": errMissingToken.Error()})
+				return
+			}
+			claims, err := s.authenticateToken(msg.Token)
+			if err != nil {
+				c.reply("error", map[string]string{"message": errInvalidToken.Error()})
+				return
+			}
+			c.authenticate(msg.Token, claims)
+			c.registered = true
+			c.resumeFrom = msg.ResumeFrom
+			c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+			c.reply("authenticated", map[string]interface{}{"topics": c.currentTopics()})
+			c.hub.register <- c
+			continue
+		}
+
+		switch msg.Type {
+		case "subscribe", "unsubscribe":
+			if err := c.setTopics(msg.Topics, msg.Type == "subscribe"); err != nil {
+				c.reply("error", map[string]string{"message": err.Error()})
+				continue
+			}
+			c.reply("subscribed", map[string]interface{}{"topics": c.currentTopics()})
+		case "resume":
+			c.hub.replay <- replayRequest{client: c, from: msg.ResumeFrom}
+		case "auth":
+			// ya autenticado
+		default:
+			c.reply("error", map[string]string{"message": "unknown message type " + msg.Type})
+		}
 	}
 }
 
-func (c *Client) writePump() {
+func (c *Client) writePump(s *Server) {
 	ticker := time.NewTicker(54 * time.Second)
+	recheck := time.NewTimer(streamSessionRecheck)
+	if sess := c.session.Load(); sess != nil {
+		recheck.Reset(nextStreamCheck(sess, time.Now()))
+	}
 	defer func() {
 		ticker.Stop()
+		recheck.Stop()
 		_ = c.conn.Close()
 	}()
 	for {
@@ -102,7 +452,7 @@ func (c *Client) writePump() {
 			if err != nil {
 				return
 			}
-			if _, err := w.Write(msg); err != nil {
+			if _, err := w.Write(msg.Payload); err != nil {
 				return
 			}
 			if err := w.Close(); err != nil {
@@ -113,30 +463,106 @@ func (c *Client) writePump() {
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-recheck.C:
+			// antes de autenticarse la conexión la corta wsAuthTimeout
+			sess := c.session.Load()
+			if sess == nil {
+				recheck.Reset(streamSessionRecheck)
+				continue
+			}
+			now := time.Now()
+			if err := s.checkStreamSession(sess, now); err != nil {
+				c.closeSession(err)
+				return
+			}
+			recheck.Reset(nextStreamCheck(sess, now))
 		}
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// closeSession avisa al cliente por qué se corta la sesión y cierra el socket;
+// readPump recibe el error de lectura y lo quita del hub.
+func (c *Client) closeSession(err error) {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_ = c.conn.WriteMessage(websocket.TextMessage, sessionEndEvent(err).Payload)
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+}
+
+// checkOrigin acepta clientes sin Origin (herramientas, no navegadores) y los
+// orígenes de ws_allowed_origins; sin configuración solo el mismo host.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if s.Config() == nil || len(s.Config().WSAllowedOrigins) == 0 {
+		return strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(origin, "http://"), "https://"), r.Host)
+	}
+	for _, allowed := range s.Config().WSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
 }
 
+// HandleWS abre el socket. El JWT puede venir en ?token= (o Authorization) o
+// como primer mensaje {"type":"auth"}; sin él la conexión se cierra tras wsAuthTimeout.
+// Con la conexión abierta el token se revalida cada streamSessionRecheck y al
+// vencer; si ya no vale se envía un error y se cierra el socket.
+// resume_from (query o mensaje auth) reenvía los eventos con seq mayor.
 func (s *Server) HandleWS(w http.ResponseWriter, r *http.Request) {
+	var claims *jwtClaims
+	token := bearerToken(r)
+	if token != "" {
+		var err error
+		claims, err = s.authenticateToken(token)
+		if err != nil {
+			s.HandleError(w, http.StatusUnauthorized, r.URL.Path, errInvalidToken)
+			return
+		}
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
-	client := &Client{hub: s.WsHub, conn: conn, send: make(chan []byte, 256)}
-	client.hub.register <- client
+	client := newClient(s.WsHub, conn)
+	if claims != nil {
+		client.authenticate(token, claims)
+		client.registered = true
+		if v := r.URL.Query().Get("resume_from"); v != "" {
+			client.resumeFrom, _ = strconv.ParseUint(v, 10, 64)
+		}
+		client.hub.register <- client
+	}
 
-	go client.writePump()
-	go client.readPump()
+	go client.writePump(s)
+	go client.readPump(s)
 }
 
-// Paquete de mensaje uniforme
+// Paquete de mensaje uniforme. Seq es monótono por arranque del servidor y solo
+// lo llevan los eventos del hub; las respuestas directas al cliente van sin seq.
 type wsEnvelope struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+	Seq       uint64      `json:"seq,omitempty"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"ts"`
+	Data      interface{} `json:"data"`
+}
+
+// eventAlchemistID extrae el alquimista al que se refiere el evento, si lo hay.
+func eventAlchemistID(data interface{}) *uint {
+	switch v := data.(type) {
+	case *api.TransmutationResponseDto:
+		id := uint(v.AlchemistID)
+		return &id
+	case *api.MissionResponseDto:
+		if v.AsignadoAID != nil {
+			id := uint(*v.AsignadoAID)
+			return &id
+		}
+	}
+	return nil
 }
 
 // ws.go
@@ -144,10 +570,10 @@ func (s *Server) notify(eventType string, data interface{}) error {
 	if s.WsHub == nil {
 		return nil
 	}
-	b, err := json.Marshal(wsEnvelope{Type: eventType, Data: data})
+	raw, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	s.WsHub.broadcast <- b
+	s.WsHub.broadcast <- &hubEvent{Type: eventType, AlchemistID: eventAlchemistID(data), Data: json.RawMessage(raw)}
 	return nil
 }